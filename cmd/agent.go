@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
+	"github.com/spf13/cobra"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Manage the node agent used by the agent executor",
+	Long: `Manage the node agent used by the agent executor.
+
+The agent is a privileged daemonset running on every node of the target
+clusters. When the agent is installed, use "--executor agent" to run
+commands in the agent pods instead of starting a new debug pod for every
+command.`,
+}
+
+var agentInstallCmd = &cobra.Command{
+	Use:   "install [flags]",
+	Short: "Install the agent on the target clusters",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := operationContext(cmd.Context())
+		defer cancel()
+
+		if err := blackhole.InstallAgent(ctx, newOptions()); err != nil {
+			errlog.Fatal(err)
+		}
+	},
+}
+
+var agentUninstallCmd = &cobra.Command{
+	Use:   "uninstall [flags]",
+	Short: "Remove the agent and leftover changes from the target clusters",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := operationContext(cmd.Context())
+		defer cancel()
+
+		if err := blackhole.UninstallAgent(ctx, newOptions()); err != nil {
+			fatalNodesError(err)
+		}
+	},
+}
+
+func init() {
+	agentCmd.AddCommand(agentInstallCmd)
+	agentCmd.AddCommand(agentUninstallCmd)
+	rootCmd.AddCommand(agentCmd)
+}
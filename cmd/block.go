@@ -4,9 +4,25 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
 	"github.com/spf13/cobra"
 )
 
+var blockMethod string
+var blockPorts []uint
+var blockProtocol string
+var onlyCategories []string
+var blockDuration time.Duration
+var blockDetach bool
+var blockVerify bool
+var blockWatch bool
+var blockNoRollback bool
+
 var blockCmd = &cobra.Command{
 	Use:   "block cluster [flags]",
 	Short: "Make a cluster unreachable from target cluster",
@@ -14,18 +30,171 @@ var blockCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		blockedContext := args[0]
 
-		c, err := NewCommand(blockedContext, targetContexts, kubeconfig, showProgress)
+		if blockDetach && blockDuration == 0 {
+			errlog.Fatal("--detach requires --duration")
+		}
+
+		if blockDetach && blockWatch {
+			errlog.Fatal("--watch cannot be used with --detach")
+		}
+
+		method, err := blackhole.ParseBlockMethod(blockMethod)
 		if err != nil {
 			errlog.Fatal(err)
 		}
 
-		err = c.BlockCluster()
+		options, err := newClusterOptions()
 		if err != nil {
 			errlog.Fatal(err)
 		}
+
+		options.Method = method
+		options.NoRollback = blockNoRollback
+
+		ctx := cmd.Context()
+
+		if blockDuration == 0 {
+			blockCluster(ctx, blockedContext, options)
+			if blockVerify {
+				if err := verifyCluster(ctx, blockedContext, blackhole.StatusBlocked, options); err != nil {
+					fatalNodesError(err)
+				}
+			}
+			if blockWatch {
+				// Watch until interrupted, keeping the cluster blocked.
+				if err := blackhole.Watch(ctx, blockedContext, options); err != nil {
+					errlog.Fatal(err)
+				}
+			}
+			return
+		}
+
+		if blockDetach {
+			options.Expire = blockDuration
+			blockCluster(ctx, blockedContext, options)
+			if blockVerify {
+				if err := verifyCluster(ctx, blockedContext, blackhole.StatusBlocked, options); err != nil {
+					fatalNodesError(err)
+				}
+			}
+			fmt.Printf("cluster %s will be unblocked at %s\n",
+				blockedContext, time.Now().Add(blockDuration).Format(time.RFC3339))
+			return
+		}
+
+		options.Expire = blockDuration + blackhole.ExpiryGrace
+		blockCluster(ctx, blockedContext, options)
+
+		var verifyErr error
+		if blockVerify {
+			verifyErr = verifyCluster(ctx, blockedContext, blackhole.StatusBlocked, options)
+		}
+
+		if verifyErr != nil {
+			errlog.Printf("%s, unblocking cluster %q", verifyErr, blockedContext)
+		} else {
+			dbglog.Printf("Cluster %q blocked for %s", blockedContext, blockDuration)
+			if !waitBlocked(ctx, blockedContext, options, blockDuration) {
+				dbglog.Printf("Interrupted, unblocking cluster %q", blockedContext)
+			}
+		}
+
+		// Unblock even if we were interrupted. Another signal will terminate
+		// the program, and the target nodes will unblock the cluster later.
+		ctx = context.WithoutCancel(ctx)
+
+		if err := unblockCluster(ctx, blockedContext, options); err != nil {
+			fatalNodesError(err)
+		}
+
+		if verifyErr != nil {
+			os.Exit(1)
+		}
+
+		if blockVerify {
+			if err := verifyCluster(ctx, blockedContext, blackhole.StatusUnblocked, options); err != nil {
+				fatalNodesError(err)
+			}
+		}
 	},
 }
 
+// blockCluster blocks the cluster, cancelling the block if --timeout expires,
+// and exits if blocking failed.
+func blockCluster(ctx context.Context, cluster string, options *blackhole.Options) {
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+
+	if _, err := blackhole.Block(ctx, cluster, options); err != nil {
+		fatalNodesError(err)
+	}
+}
+
+// waitBlocked waits for duration, or until the context is done, watching the
+// cluster if --watch was specified. Returns false if the context was done
+// before the duration passed.
+func waitBlocked(ctx context.Context, cluster string, options *blackhole.Options, duration time.Duration) bool {
+	if !blockWatch {
+		return sleep(ctx, duration)
+	}
+
+	watchCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	if err := blackhole.Watch(watchCtx, cluster, options); err != nil {
+		errlog.Printf("Cannot watch cluster %q: %s", cluster, err)
+		<-watchCtx.Done()
+	}
+
+	return ctx.Err() == nil
+}
+
+// sleep waits for duration, or until the context is done. Returns false if the
+// context was done before the duration passed.
+func sleep(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func addMethodFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&blockMethod, "method", string(blackhole.MethodRoute),
+		"how to block the cluster (route, nft-drop, nft-reject)")
+}
+
+// addFilterFlags adds flags limiting the command to some ports and protocol.
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().UintSliceVar(&blockPorts, "ports", nil,
+		"block only these destination ports (e.g. 6443,443); requires nft method")
+	cmd.Flags().StringVar(&blockProtocol, "protocol", "",
+		"block only this protocol (tcp, udp); requires nft method")
+}
+
+// addCategoriesFlag adds a flag selecting the cluster address categories.
+func addCategoriesFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&onlyCategories, "only", nil,
+		"use only these cluster address categories (api, nodes, routes)")
+}
+
 func init() {
+	addMethodFlag(blockCmd)
+	addFilterFlags(blockCmd)
+	addCategoriesFlag(blockCmd)
+	blockCmd.Flags().DurationVar(&blockDuration, "duration", 0,
+		"unblock the cluster after duration (e.g. 10m)")
+	blockCmd.Flags().BoolVar(&blockDetach, "detach", false,
+		"return after blocking, and let the target nodes unblock the cluster when the duration expires")
+	blockCmd.Flags().BoolVar(&blockWatch, "watch", false,
+		"keep blocking new target nodes and new cluster addresses until interrupted or the duration expires")
+	blockCmd.Flags().BoolVar(&blockNoRollback, "no-rollback", false,
+		"keep the nodes blocked if blocking fails on other nodes")
+	blockCmd.Flags().BoolVar(&blockVerify, "verify", false,
+		"verify that the cluster is unreachable after blocking, and reachable after unblocking")
 	rootCmd.AddCommand(blockCmd)
 }
@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
+	"github.com/spf13/cobra"
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup [flags]",
+	Short: "Remove all changes from the target clusters",
+	Long: `Remove all changes from the target clusters.
+
+Removes the blocks of all blocked clusters, the network degradation, the
+scheduled expiry, and the block records from all target nodes. Unlike
+"unblock", this uses only the target contexts, so it works when the blocked
+cluster is unreachable or was destroyed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cleanupTargets(cmd.Context(), true)
+	},
+}
+
+// cleanupTargets cleans up the target clusters, and exits if cleaning up
+// failed. If restore is false, only blocks are removed.
+func cleanupTargets(ctx context.Context, restore bool) {
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+
+	if err := blackhole.Cleanup(ctx, newOptions(), restore); err != nil {
+		fatalNodesError(err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(cleanupCmd)
+}
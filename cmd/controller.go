@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var hubContext string
+var controllerNamespace string
+
+var controllerCmd = &cobra.Command{
+	Use:   "controller",
+	Short: "Manage blocks using BlackholePolicy resources on a hub cluster",
+	Long: `Manage blocks using BlackholePolicy resources on a hub cluster.
+
+A BlackholePolicy declares the blocked cluster, the target clusters, the
+blocking method, and optional start time and duration. The controller blocks
+the cluster while the policy is active, unblocks it when the policy expires
+or is deleted, and reports the status of every target node in the policy
+status. The clusters are kubeconfig contexts available to the controller.`,
+}
+
+var controllerInstallCmd = &cobra.Command{
+	Use:   "install [flags]",
+	Short: "Install the BlackholePolicy custom resource definition on the hub",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := hubClient()
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		if err := blackhole.InstallPolicyCRD(cmd.Context(), client); err != nil {
+			errlog.Fatal(err)
+		}
+	},
+}
+
+var controllerRunCmd = &cobra.Command{
+	Use:   "run [flags]",
+	Short: "Run the controller until interrupted",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := hubClient()
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		controller := blackhole.NewController(client, controllerNamespace, newOptions())
+
+		if err := controller.Run(cmd.Context()); err != nil {
+			errlog.Fatal(err)
+		}
+	},
+}
+
+// hubClient returns a dynamic client for the hub context.
+func hubClient() (dynamic.Interface, error) {
+	config, err := clientcmd.LoadFromFile(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	hub := hubContext
+	if hub == "" {
+		hub = config.CurrentContext
+	}
+	if _, ok := config.Contexts[hub]; !ok {
+		return nil, fmt.Errorf("unknown hub context %q", hub)
+	}
+
+	restConfig, err := clientcmd.NewNonInteractiveClientConfig(*config, hub, nil, nil).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamic.NewForConfig(restConfig)
+}
+
+func init() {
+	controllerCmd.PersistentFlags().StringVar(&hubContext, "hub", "",
+		"the kubeconfig context of the hub cluster (default current context)")
+	controllerRunCmd.Flags().StringVar(&controllerNamespace, "namespace", "",
+		"watch policies only in this namespace (default all namespaces)")
+	controllerCmd.AddCommand(controllerInstallCmd)
+	controllerCmd.AddCommand(controllerRunCmd)
+	rootCmd.AddCommand(controllerCmd)
+}
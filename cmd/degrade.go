@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
+	"github.com/spf13/cobra"
+)
+
+var netemOptions blackhole.NetemOptions
+
+var degradeCmd = &cobra.Command{
+	Use:   "degrade cluster [flags]",
+	Short: "Degrade the network to a cluster from target cluster",
+	Long: `Degrade the network to a cluster from target cluster.
+
+Adds delay, packet loss, or bandwidth limit to the traffic from the target
+nodes to the cluster addresses, using tc netem. Degrading a degraded cluster
+replaces the previous parameters without changing other degraded clusters.
+Use "restore" to remove the degradation.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		blockedContext := args[0]
+
+		options, err := newClusterOptions()
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		ctx, cancel := operationContext(cmd.Context())
+		defer cancel()
+
+		err = blackhole.Degrade(ctx, blockedContext, &netemOptions, options)
+		if err != nil {
+			fatalNodesError(err)
+		}
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore cluster [flags]",
+	Short: "Remove the network degradation to a cluster from target cluster",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		blockedContext := args[0]
+
+		options, err := newClusterOptions()
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		ctx, cancel := operationContext(cmd.Context())
+		defer cancel()
+
+		err = blackhole.Restore(ctx, blockedContext, options)
+		if err != nil {
+			fatalNodesError(err)
+		}
+	},
+}
+
+func init() {
+	addCategoriesFlag(degradeCmd)
+	addCategoriesFlag(restoreCmd)
+	degradeCmd.Flags().DurationVar(&netemOptions.Delay, "delay", 0,
+		"delay added to every packet (e.g. 200ms)")
+	degradeCmd.Flags().DurationVar(&netemOptions.Jitter, "jitter", 0,
+		"random variation of the delay (e.g. 50ms)")
+	degradeCmd.Flags().StringVar(&netemOptions.Loss, "loss", "",
+		"percentage of dropped packets (e.g. 5%)")
+	degradeCmd.Flags().StringVar(&netemOptions.Rate, "rate", "",
+		"maximum bandwidth (e.g. 10mbit)")
+	rootCmd.AddCommand(degradeCmd)
+	rootCmd.AddCommand(restoreCmd)
+}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
+	"github.com/spf13/cobra"
+)
+
+var flapUp time.Duration
+var flapDown time.Duration
+var flapJitter time.Duration
+var flapCycles int
+
+var flapCmd = &cobra.Command{
+	Use:   "flap cluster [flags]",
+	Short: "Make a cluster repeatedly unreachable and reachable from target cluster",
+	Long: `Make a cluster repeatedly unreachable and reachable from target cluster.
+
+Every cycle blocks the cluster for the --down duration, and unblocks it for
+the --up duration. Every duration is randomly changed by up to --jitter.
+Every transition is reported with a timestamp. Interrupting the command
+unblocks the cluster.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		blockedContext := args[0]
+
+		if flapCycles < 0 {
+			errlog.Fatalf("invalid number of cycles: %d", flapCycles)
+		}
+
+		method, err := blackhole.ParseBlockMethod(blockMethod)
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		options, err := newClusterOptions()
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		options.Method = method
+
+		ctx := cmd.Context()
+
+		for cycle := 1; flapCycles == 0 || cycle <= flapCycles; cycle++ {
+			down := jitter(flapDown, flapJitter)
+
+			// If we are killed, the target nodes will unblock the cluster.
+			options.Expire = down + blackhole.ExpiryGrace
+			blockCluster(ctx, blockedContext, options)
+			reportTransition(cycle, blackhole.StatusBlocked)
+
+			interrupted := !sleep(ctx, down)
+
+			// Unblock even if we were interrupted.
+			err := unblockCluster(context.WithoutCancel(ctx), blockedContext, options)
+			if err != nil {
+				fatalNodesError(err)
+			}
+			reportTransition(cycle, blackhole.StatusUnblocked)
+
+			if interrupted || cycle == flapCycles {
+				break
+			}
+
+			if !sleep(ctx, jitter(flapUp, flapJitter)) {
+				break
+			}
+		}
+	},
+}
+
+func reportTransition(cycle int, status blackhole.BlackholeStatus) {
+	fmt.Printf("%s cycle %d %s\n", time.Now().Format(time.RFC3339), cycle, status)
+}
+
+// jitter returns duration randomly changed by up to amount, but not less than
+// zero.
+func jitter(duration time.Duration, amount time.Duration) time.Duration {
+	if amount <= 0 {
+		return duration
+	}
+	res := duration + time.Duration(rand.Int63n(int64(2*amount)+1)) - amount
+	if res < 0 {
+		return 0
+	}
+	return res
+}
+
+func init() {
+	addMethodFlag(flapCmd)
+	addCategoriesFlag(flapCmd)
+	flapCmd.Flags().DurationVar(&flapUp, "up", time.Minute,
+		"how long the cluster is reachable in every cycle")
+	flapCmd.Flags().DurationVar(&flapDown, "down", time.Minute,
+		"how long the cluster is unreachable in every cycle")
+	flapCmd.Flags().DurationVar(&flapJitter, "jitter", 0,
+		"randomly change every duration by up to jitter")
+	flapCmd.Flags().IntVar(&flapCycles, "cycles", 0,
+		"number of cycles (0 means until interrupted)")
+	rootCmd.AddCommand(flapCmd)
+}
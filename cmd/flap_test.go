@@ -0,0 +1,33 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		d := jitter(time.Minute, 10*time.Second)
+		if d < 50*time.Second || d > 70*time.Second {
+			t.Fatalf("jitter out of range: %s", d)
+		}
+	}
+}
+
+func TestJitterNotNegative(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		d := jitter(time.Second, time.Minute)
+		if d < 0 {
+			t.Fatalf("negative jitter: %s", d)
+		}
+	}
+}
+
+func TestJitterDisabled(t *testing.T) {
+	if d := jitter(time.Minute, 0); d != time.Minute {
+		t.Fatalf("expected %s, got %s", time.Minute, d)
+	}
+}
@@ -4,6 +4,7 @@
 package cmd
 
 import (
+	"errors"
 	"io"
 	"log"
 	"os"
@@ -22,3 +23,19 @@ func (w verboseWriter) Write(b []byte) (int, error) {
 
 var dbglog = log.New(verboseWriter{os.Stdout}, "", 0)
 var errlog = log.New(os.Stderr, "", 0)
+
+// outcomesError is implemented by errors reporting the outcome of every node.
+type outcomesError interface {
+	error
+	Write(io.Writer) error
+}
+
+// fatalNodesError reports the outcome of every node if the operation failed
+// on some nodes, and exits.
+func fatalNodesError(err error) {
+	var outcomesErr outcomesError
+	if errors.As(err, &outcomesErr) {
+		outcomesErr.Write(os.Stderr)
+	}
+	errlog.Fatal(err)
+}
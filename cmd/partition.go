@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
+	"github.com/spf13/cobra"
+)
+
+var partitionGroups []string
+
+var partitionCmd = &cobra.Command{
+	Use:   "partition --group cluster,... --group cluster,... [flags]",
+	Short: "Split clusters into groups unreachable from each other",
+	Long: `Split clusters into groups unreachable from each other.
+
+Blocks every cluster of every group in all clusters of the other groups, so
+clusters can reach only clusters in the same group. If blocking a cluster
+fails, the blocks added by the partition are rolled back, unless
+--no-rollback is specified. Use "heal" with the same groups to remove the
+partition.`,
+	Example: `  # Split the hub and cluster1 from cluster2:
+  oc blackhole partition --group hub,cluster1 --group cluster2`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		groups, err := parseGroups(partitionGroups)
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		method, err := blackhole.ParseBlockMethod(blockMethod)
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		options, err := newGroupOptions()
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		options.Method = method
+		options.NoRollback = blockNoRollback
+
+		ctx, cancel := operationContext(cmd.Context())
+		defer cancel()
+
+		if _, err := blackhole.Partition(ctx, groups, options); err != nil {
+			fatalNodesError(err)
+		}
+	},
+}
+
+var healCmd = &cobra.Command{
+	Use:   "heal --group cluster,... --group cluster,... [flags]",
+	Short: "Make partitioned groups reachable again from each other",
+	Long: `Make partitioned groups reachable again from each other.
+
+Unblocks every cluster of every group in all clusters of the other groups.
+All clusters are unblocked even if unblocking some of them fails.`,
+	Example: `  # Make the hub and cluster1 reachable again from cluster2:
+  oc blackhole heal --group hub,cluster1 --group cluster2`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		groups, err := parseGroups(partitionGroups)
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		options, err := newGroupOptions()
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		ctx, cancel := operationContext(cmd.Context())
+		defer cancel()
+
+		if _, err := blackhole.Heal(ctx, groups, options); err != nil {
+			fatalNodesError(err)
+		}
+	},
+}
+
+// parseGroups returns the clusters of every --group flag.
+func parseGroups(values []string) ([][]string, error) {
+	var groups [][]string
+	for _, value := range values {
+		var group []string
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				group = append(group, name)
+			}
+		}
+		if len(group) == 0 {
+			return nil, fmt.Errorf("empty group %q", value)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// newGroupOptions returns the options for the partition and heal commands.
+// The targets are the clusters of the other groups, so --contexts is not
+// accepted.
+func newGroupOptions() (*blackhole.Options, error) {
+	if len(targetContexts) != 0 {
+		return nil, fmt.Errorf("--contexts cannot be used with --group")
+	}
+
+	return newClusterOptions()
+}
+
+// addGroupFlag adds a flag specifying a group of clusters. The flag can be
+// repeated, and every value is a separate group.
+func addGroupFlag(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&partitionGroups, "group", nil,
+		"comma separated contexts of clusters in the same group (repeat for every group)")
+	cmd.MarkFlagRequired("group")
+}
+
+func init() {
+	addGroupFlag(partitionCmd)
+	addMethodFlag(partitionCmd)
+	addFilterFlags(partitionCmd)
+	addCategoriesFlag(partitionCmd)
+	partitionCmd.Flags().BoolVar(&blockNoRollback, "no-rollback", false,
+		"keep the clusters blocked if blocking other clusters fails")
+	addGroupFlag(healCmd)
+	addFilterFlags(healCmd)
+	addCategoriesFlag(healCmd)
+	rootCmd.AddCommand(partitionCmd)
+	rootCmd.AddCommand(healCmd)
+}
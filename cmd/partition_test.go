@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGroups(t *testing.T) {
+	groups, err := parseGroups([]string{"hub,cluster1", " cluster2 "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{{"hub", "cluster1"}, {"cluster2"}}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("expected %v, got %v", expected, groups)
+	}
+}
+
+func TestParseGroupsEmpty(t *testing.T) {
+	if _, err := parseGroups([]string{"hub", ","}); err == nil {
+		t.Fatal("empty group did not fail")
+	}
+}
@@ -4,16 +4,27 @@
 package cmd
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
 	"github.com/spf13/cobra"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 var targetContexts []string
 var kubeconfig string
+var executorName string
 var verbose bool
 var showProgress bool
+var operationTimeout time.Duration
+var nodeTimeout time.Duration
+var nodeRetries int
+var parallelNodes int
+var parallelPerTarget int
 
 var example = `  # Make cluster 'foo' unreachable from clusters 'bar' and 'baz':
   oc blackhole block foo --contexts bar,baz
@@ -31,12 +42,68 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
-	err := rootCmd.Execute()
+	// The first signal cancels in-flight work; restore default signal
+	// handling so another signal terminates the program.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	ctx = blackhole.WithLogger(ctx, dbglog)
+	ctx = blackhole.WithErrorLogger(ctx, errlog)
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
+// newOptions returns the library options for the command line flags.
+func newOptions() *blackhole.Options {
+	options := &blackhole.Options{
+		Kubeconfig:        kubeconfig,
+		Targets:           targetContexts,
+		Executor:          executorName,
+		NodeTimeout:       nodeTimeout,
+		Retries:           nodeRetries,
+		Parallel:          parallelNodes,
+		ParallelPerTarget: parallelPerTarget,
+	}
+	if showProgress {
+		options.ProgressOutput = os.Stderr
+	}
+	return options
+}
+
+// newClusterOptions returns the library options for commands modifying or
+// inspecting a cluster, using the --protocol, --ports, and --only flags.
+func newClusterOptions() (*blackhole.Options, error) {
+	filter, err := blackhole.ParsePortFilter(blockProtocol, blockPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := blackhole.ParseCategories(onlyCategories)
+	if err != nil {
+		return nil, err
+	}
+
+	options := newOptions()
+	options.Filter = filter
+	options.Categories = categories
+	return options, nil
+}
+
+// operationContext returns a context for a single operation such as blocking
+// or unblocking a cluster, cancelled when --timeout expires.
+func operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if operationTimeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, operationTimeout)
+}
+
 func defaultKubeconfig() string {
 	env := os.Getenv("KUBECONFIG")
 	if env != "" {
@@ -50,6 +117,18 @@ func init() {
 		"the kubeconfig contexts of the target clusters")
 	rootCmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", defaultKubeconfig(),
 		"the kubeconfig file to use")
+	rootCmd.PersistentFlags().StringVar(&executorName, "executor", blackhole.ExecutorOcDebug,
+		"how to run commands on target nodes (oc-debug, pod, agent)")
+	rootCmd.PersistentFlags().DurationVar(&operationTimeout, "timeout", 0,
+		"cancel the operation if it takes more than timeout (e.g. 10m); 0 means no timeout")
+	rootCmd.PersistentFlags().DurationVar(&nodeTimeout, "node-timeout", blackhole.DefaultNodeTimeout,
+		"cancel a command on a node if it takes more than timeout; 0 means no timeout")
+	rootCmd.PersistentFlags().IntVar(&nodeRetries, "retries", blackhole.DefaultRetries,
+		"retry commands on a node failing with a transient error up to retries times")
+	rootCmd.PersistentFlags().IntVar(&parallelNodes, "parallel", blackhole.DefaultParallel,
+		"maximum number of nodes to modify or inspect concurrently; 0 means no limit")
+	rootCmd.PersistentFlags().IntVar(&parallelPerTarget, "parallel-per-target", 0,
+		"maximum number of nodes of the same target to modify or inspect concurrently; 0 means no limit")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false,
 		"be more verbose")
 	rootCmd.PersistentFlags().BoolVarP(&showProgress, "progress", "p", false,
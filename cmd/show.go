@@ -4,12 +4,14 @@
 package cmd
 
 import (
-	"fmt"
-	"sort"
+	"os"
 
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
 	"github.com/spf13/cobra"
 )
 
+var outputFormat string
+
 var showCmd = &cobra.Command{
 	Use:   "show cluster",
 	Short: "Show if cluster is in blackhole",
@@ -17,40 +19,45 @@ var showCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		blockedContext := args[0]
 
-		c, err := NewCommand(blockedContext, targetContexts, kubeconfig, showProgress)
+		format, err := blackhole.ParseOutputFormat(outputFormat)
 		if err != nil {
 			errlog.Fatal(err)
 		}
 
-		status, err := c.ClusterStatus()
+		options, err := newClusterOptions()
 		if err != nil {
 			errlog.Fatal(err)
 		}
 
-		fmt.Printf("status:\n")
-		fmt.Printf("  cluster: %s\n", blockedContext)
-		fmt.Printf("  targets:\n")
-		for targetName, targetStatus := range status {
-			fmt.Printf("    - name: %s\n", targetName)
-			fmt.Printf("      valid: %v\n", targetStatus.Valid)
-			fmt.Printf("      nodes:\n")
-			for _, nodeName := range sortedKeys(targetStatus.Nodes) {
-				fmt.Printf("        - name: %s\n", nodeName)
-				fmt.Printf("          status: %s\n", targetStatus.Nodes[nodeName])
+		ctx, cancel := operationContext(cmd.Context())
+		defer cancel()
+
+		status, err := blackhole.Status(ctx, blockedContext, options)
+		if err != nil {
+			fatalNodesError(err)
+		}
+
+		// The blocked and missing addresses are shown only in json and wide
+		// output.
+		if format == blackhole.OutputYAML {
+			for i := range status.Targets {
+				for j := range status.Targets[i].Nodes {
+					status.Targets[i].Nodes[j].Details = nil
+				}
 			}
 		}
-	},
-}
 
-func sortedKeys(m map[string]BlackholeStatus) []string {
-	keys := make([]string, 0, len(m))
-	for key := range m {
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
-	return keys
+		out := &blackhole.ShowOutput{Status: *status}
+		if err := out.Write(os.Stdout, format); err != nil {
+			errlog.Fatal(err)
+		}
+	},
 }
 
 func init() {
+	addCategoriesFlag(showCmd)
+	addFilterFlags(showCmd)
+	showCmd.Flags().StringVarP(&outputFormat, "output", "o", string(blackhole.OutputYAML),
+		"output format (yaml, json, table, wide)")
 	rootCmd.AddCommand(showCmd)
 }
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status [flags]",
+	Short: "Show which clusters block which clusters",
+	Long: `Show which clusters block which clusters.
+
+Inspects every node of every cluster, and maps the blocked addresses back to
+the clusters. If --contexts is not specified, all contexts in the kubeconfig
+are used. Addresses blocked on a cluster that do not belong to any of the
+contexts are reported as unknown. If some clusters could not be inspected,
+the errors are reported to stderr, and the command exits with a non-zero
+exit code.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := operationContext(cmd.Context())
+		defer cancel()
+
+		matrix, err := blackhole.InspectStatus(ctx, newOptions())
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		matrix.Write(os.Stdout)
+
+		if err := matrix.Err(); err != nil {
+			errlog.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
@@ -4,28 +4,67 @@
 package cmd
 
 import (
+	"context"
+
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
 	"github.com/spf13/cobra"
 )
 
+var unblockAll bool
+var unblockVerify bool
+
 var unblockCmd = &cobra.Command{
-	Use:   "unblock cluster [flags]",
+	Use:   "unblock [cluster] [flags]",
 	Short: "Make cluster reachable again from target cluster",
-	Args:  cobra.ExactArgs(1),
+	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if unblockAll {
+			if len(args) != 0 {
+				errlog.Fatal("--all does not accept a cluster")
+			}
+			cleanupTargets(cmd.Context(), false)
+			return
+		}
+
+		if len(args) != 1 {
+			errlog.Fatal("no cluster specified")
+		}
+
 		blockedContext := args[0]
 
-		c, err := NewCommand(blockedContext, targetContexts, kubeconfig, showProgress)
+		options, err := newClusterOptions()
 		if err != nil {
 			errlog.Fatal(err)
 		}
 
-		err = c.UnblockCluster()
-		if err != nil {
-			errlog.Fatal(err)
+		if err := unblockCluster(cmd.Context(), blockedContext, options); err != nil {
+			fatalNodesError(err)
+		}
+
+		if unblockVerify {
+			if err := verifyCluster(cmd.Context(), blockedContext, blackhole.StatusUnblocked, options); err != nil {
+				fatalNodesError(err)
+			}
 		}
 	},
 }
 
+// unblockCluster unblocks the cluster, cancelling the unblock if --timeout
+// expires.
+func unblockCluster(ctx context.Context, cluster string, options *blackhole.Options) error {
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+
+	_, err := blackhole.Unblock(ctx, cluster, options)
+	return err
+}
+
 func init() {
+	addCategoriesFlag(unblockCmd)
+	addFilterFlags(unblockCmd)
+	unblockCmd.Flags().BoolVar(&unblockAll, "all", false,
+		"unblock all blocked clusters using only the target contexts")
+	unblockCmd.Flags().BoolVar(&unblockVerify, "verify", false,
+		"verify that the cluster is reachable after unblocking")
 	rootCmd.AddCommand(unblockCmd)
 }
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/nirs/oc-blackhole/pkg/blackhole"
+	"github.com/spf13/cobra"
+)
+
+var verifyExpect string
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify cluster [flags]",
+	Short: "Verify that a cluster is unreachable from target cluster",
+	Long: `Verify that a cluster is unreachable from target cluster.
+
+Runs probes from every target node to the cluster addresses: tcp connect to the
+API server port, and https request to a route host for every route address.
+Node addresses are not probed. Reports if every address is reachable, and fails
+if the cluster does not have the expected status.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		blockedContext := args[0]
+
+		expected, err := parseExpectedStatus(verifyExpect)
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		options, err := newClusterOptions()
+		if err != nil {
+			errlog.Fatal(err)
+		}
+
+		if err := verifyCluster(cmd.Context(), blockedContext, expected, options); err != nil {
+			fatalNodesError(err)
+		}
+	},
+}
+
+// parseExpectedStatus returns the status named name. Only blocked and
+// unblocked can be verified.
+func parseExpectedStatus(name string) (blackhole.BlackholeStatus, error) {
+	switch status := blackhole.BlackholeStatus(name); status {
+	case blackhole.StatusBlocked, blackhole.StatusUnblocked:
+		return status, nil
+	default:
+		return "", fmt.Errorf("cannot verify status %q", name)
+	}
+}
+
+// verifyCluster runs the probes, writes the results, and returns an error if
+// the cluster does not have the expected status.
+func verifyCluster(ctx context.Context, cluster string, expected blackhole.BlackholeStatus, options *blackhole.Options) error {
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+
+	verification, err := blackhole.Verify(ctx, cluster, expected, options)
+	if err != nil {
+		return err
+	}
+
+	if err := verification.Write(os.Stdout); err != nil {
+		return err
+	}
+
+	return verification.Err()
+}
+
+func init() {
+	addCategoriesFlag(verifyCmd)
+	addFilterFlags(verifyCmd)
+	verifyCmd.Flags().StringVar(&verifyExpect, "expect", string(blackhole.StatusBlocked),
+		"the expected cluster status (blocked, unblocked)")
+	rootCmd.AddCommand(verifyCmd)
+}
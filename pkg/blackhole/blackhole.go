@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package blackhole makes a cluster unreachable from other clusters by
+// blocking the cluster addresses on the nodes of the target clusters.
+//
+// The clusters are specified by kubeconfig contexts, or by rest configs for
+// callers without a kubeconfig file:
+//
+//	options := &blackhole.Options{
+//		Targets:     []string{"hub", "dr2"},
+//		NodeTimeout: blackhole.DefaultNodeTimeout,
+//		Retries:     blackhole.DefaultRetries,
+//		Parallel:    blackhole.DefaultParallel,
+//	}
+//	if _, err := blackhole.Block(ctx, "dr1", options); err != nil {
+//		return err
+//	}
+//	defer blackhole.Unblock(ctx, "dr1", options)
+package blackhole
+
+import (
+	"context"
+)
+
+// Result describes the outcome of an operation on every target node.
+type Result struct {
+	Cluster  string
+	Outcomes []NodeOutcome
+}
+
+// Block blocks cluster in all nodes of the options targets. An unknown
+// options.Method, or an invalid options.Filter, is an error. If blocking fails
+// on some nodes, the nodes are rolled back unless options.NoRollback is set,
+// and the error is a *BlockError describing every node.
+func Block(ctx context.Context, cluster string, options *Options) (*Result, error) {
+	ctx = options.context(ctx)
+
+	if err := options.checkBlock(); err != nil {
+		return nil, err
+	}
+
+	c, err := newCommand(cluster, options)
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes, err := c.blockCluster(ctx, options.blockOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Cluster: cluster, Outcomes: outcomes}, nil
+}
+
+// Unblock unblocks traffic matching options.Filter to cluster in all nodes of
+// the options targets. If unblocking fails on some nodes, the error is a
+// *NodesError describing every node.
+func Unblock(ctx context.Context, cluster string, options *Options) (*Result, error) {
+	ctx = options.context(ctx)
+
+	if err := options.Filter.check(); err != nil {
+		return nil, err
+	}
+
+	c, err := newCommand(cluster, options)
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes, err := c.unblockCluster(ctx, options.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Cluster: cluster, Outcomes: outcomes}, nil
+}
+
+// Verify runs probes matching options.Filter from all nodes of the options
+// targets to the cluster addresses, and returns the results. Use
+// Verification.Err to check if the cluster has the expected status.
+func Verify(ctx context.Context, cluster string, expected BlackholeStatus, options *Options) (*Verification, error) {
+	ctx = options.context(ctx)
+
+	if err := options.Filter.check(); err != nil {
+		return nil, err
+	}
+
+	c, err := newCommand(cluster, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.VerifyCluster(ctx, expected, options.Filter)
+}
+
+// Watch keeps cluster blocked in all nodes of the options targets until ctx is
+// done, blocking new target nodes and new cluster addresses.
+func Watch(ctx context.Context, cluster string, options *Options) error {
+	ctx = options.context(ctx)
+
+	if err := options.checkBlock(); err != nil {
+		return err
+	}
+
+	c, err := newCommand(cluster, options)
+	if err != nil {
+		return err
+	}
+
+	return c.WatchCluster(ctx, options.blockOptions())
+}
+
+// Degrade degrades the network to cluster in all nodes of the options
+// targets. Degrading a degraded cluster replaces the netem parameters.
+func Degrade(ctx context.Context, cluster string, netem *NetemOptions, options *Options) error {
+	ctx = options.context(ctx)
+
+	c, err := newCommand(cluster, options)
+	if err != nil {
+		return err
+	}
+
+	return c.DegradeCluster(ctx, netem)
+}
+
+// Restore removes the network degradation to cluster in all nodes of the
+// options targets.
+func Restore(ctx context.Context, cluster string, options *Options) error {
+	ctx = options.context(ctx)
+
+	c, err := newCommand(cluster, options)
+	if err != nil {
+		return err
+	}
+
+	return c.RestoreCluster(ctx)
+}
+
+// Status returns the status of traffic matching options.Filter to cluster in
+// all nodes of the options targets, including the blocked and missing
+// addresses on every node. If inspecting fails on some nodes, the error is a
+// *NodesError describing every node.
+func Status(ctx context.Context, cluster string, options *Options) (*ShowStatus, error) {
+	ctx = options.context(ctx)
+
+	if err := options.Filter.check(); err != nil {
+		return nil, err
+	}
+
+	c, err := newCommand(cluster, options)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := c.clusterStatus(ctx, options.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	out := newShowOutput(cluster, options.Targets, status, true)
+	return &out.Status, nil
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+)
+
+// Cleanup removes the blocks of all blocked clusters and the block records
+// from the options targets, without contacting the blocked clusters. If
+// restore is true, network degradation is removed as well.
+func Cleanup(ctx context.Context, options *Options, restore bool) error {
+	ctx = options.context(ctx)
+
+	targets, err := loadTargets(options)
+	if err != nil {
+		return err
+	}
+
+	executor, err := newExecutor(options, targets)
+	if err != nil {
+		return err
+	}
+
+	return cleanupTargets(ctx, executor, options.pool(), targets, restore)
+}
+
+// cleanupTargets removes the blocks of all blocked clusters and the block
+// records from the target clusters, without contacting the blocked clusters.
+// If restore is true, network degradation is removed as well.
+func cleanupTargets(ctx context.Context, executor nodeExecutor, pool *nodePool, targets []*targetCluster, restore bool) error {
+	errors := make(chan error)
+
+	for i := range targets {
+		target := targets[i]
+		go func() {
+			dbglog(ctx).Printf("Inspecting target %q ...", target.Context)
+			errors <- target.Inspect(ctx)
+		}()
+	}
+
+	if err := collectErrors(errors, len(targets)); err != nil {
+		return err
+	}
+
+	err := modifyTargets(ctx, pool, "cleaning up targets", targets, func(target *targetCluster, nodeName string) error {
+		if restore {
+			return clearNode(ctx, executor, target.Context, nodeName)
+		}
+		return unblockAllNode(ctx, executor, target.Context, nodeName)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if err := deleteAllRecords(ctx, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
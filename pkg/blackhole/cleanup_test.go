@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestCleanupTargetsUnblock(t *testing.T) {
+	executor := newFakeExecutor()
+	// A platform route, and an unmarked route added by an older version.
+	executor.AddForeignRoutes("hub", "hub-1", "10.0.0.1 proto static", "10.0.0.2")
+	c := newFakeCommand(executor)
+
+	options := &blockOptions{Method: MethodRoute, Expire: 10 * time.Minute}
+	if err := c.BlockCluster(context.TODO(), options); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DegradeCluster(context.TODO(), &NetemOptions{Delay: 100 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The blocked cluster is gone.
+	c.Cluster.server = "https://[invalid"
+
+	if err := cleanupTargets(context.TODO(), executor, c.pool, c.Targets, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range c.Targets {
+		for _, node := range target.NodeNames {
+			if routes := executor.Routes(target.Context, node); routes.Len() != 0 {
+				t.Errorf("routes not removed from %s/%s: %v", target.Context, node, sets.List(routes))
+			}
+			if timers := executor.Timers(target.Context, node); len(timers) != 0 {
+				t.Errorf("timers not cancelled in %s/%s: %v", target.Context, node, timers)
+			}
+			if degraded := executor.Degraded(target.Context, node); degraded.Len() == 0 {
+				t.Errorf("degradation removed from %s/%s", target.Context, node)
+			}
+		}
+
+		record, err := readRecord(context.TODO(), target, "blocked")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record != nil {
+			t.Errorf("record not deleted in %s: %+v", target.Context, record)
+		}
+	}
+
+	expected := sets.New("10.0.0.1 proto static")
+	if foreign := executor.ForeignRoutes("hub", "hub-1"); !foreign.Equal(expected) {
+		t.Errorf("expected foreign blackholes %v, got %v", sets.List(expected), sets.List(foreign))
+	}
+}
+
+func TestCleanupTargetsRestore(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodNftDrop}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DegradeCluster(context.TODO(), &NetemOptions{Delay: 100 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cleanupTargets(context.TODO(), executor, c.pool, c.Targets, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range c.Targets {
+		for _, node := range target.NodeNames {
+			if set := executor.NftSet(target.Context, node, "drop_v4"); set.Len() != 0 {
+				t.Errorf("nft elements not removed from %s/%s: %v", target.Context, node, sets.List(set))
+			}
+			if degraded := executor.Degraded(target.Context, node); degraded.Len() != 0 {
+				t.Errorf("degradation not removed from %s/%s: %v", target.Context, node, sets.List(degraded))
+			}
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	routev1 "github.com/openshift/client-go/route/clientset/versioned/typed/route/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// AddressCategory is a kind of blocked cluster addresses.
+type AddressCategory string
+
+const (
+	// The API server addresses.
+	CategoryAPI = AddressCategory("api")
+
+	// The nodes external addresses.
+	CategoryNodes = AddressCategory("nodes")
+
+	// The routes (ingress) addresses.
+	CategoryRoutes = AddressCategory("routes")
+)
+
+// AllCategories are the address categories blocked by default.
+var AllCategories = []AddressCategory{CategoryAPI, CategoryNodes, CategoryRoutes}
+
+// ParseCategories returns the categories named in names. If names is empty,
+// returns all categories.
+func ParseCategories(names []string) ([]AddressCategory, error) {
+	if len(names) == 0 {
+		return AllCategories, nil
+	}
+
+	seen := sets.New[AddressCategory]()
+	var res []AddressCategory
+
+	for _, name := range names {
+		category := AddressCategory(name)
+		switch category {
+		case CategoryAPI, CategoryNodes, CategoryRoutes:
+		default:
+			return nil, fmt.Errorf("unknown address category %q", name)
+		}
+		if !seen.Has(category) {
+			seen.Insert(category)
+			res = append(res, category)
+		}
+	}
+
+	return res, nil
+}
+
+// AddressSource describes where a cluster address was found.
+type AddressSource struct {
+	Category AddressCategory `json:"category"`
+
+	// The node name, API server host name, or route "namespace/name".
+	Name string `json:"name"`
+}
+
+type blockedCluster struct {
+	Context            string
+	NodeAddresses      []string
+	APIServerAddresses []string
+	RouteAddresses     []string
+
+	// Sources of every address. An address may have multiple sources, for
+	// example when several routes use the same ingress address.
+	Sources map[string][]AddressSource
+
+	// The API server host name and port, used to probe the API server.
+	APIServerHost string
+	APIServerPort uint16
+
+	// Route host names per route address, used to probe the routes.
+	RouteHosts map[string][]string
+
+	server      string
+	k8sClient   kubernetes.Interface
+	routeClient routev1.RouteV1Interface
+}
+
+type targetCluster struct {
+	Context    string
+	NodeNames  []string
+	restConfig *rest.Config
+	k8sClient  kubernetes.Interface
+}
+
+// newBlockedCluster returns a blocked cluster using the specified clients. The
+// server is the cluster API server URL.
+func newBlockedCluster(
+	context string,
+	server string,
+	k8sClient kubernetes.Interface,
+	routeClient routev1.RouteV1Interface,
+) *blockedCluster {
+	return &blockedCluster{
+		Context:     context,
+		server:      server,
+		k8sClient:   k8sClient,
+		routeClient: routeClient,
+	}
+}
+
+// loadBlockedCluster returns a blocked cluster with clients created from the
+// context rest config.
+func loadBlockedCluster(context string, restConfig *rest.Config) (*blockedCluster, error) {
+	k8sClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	routeClient, err := routev1.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlockedCluster(context, serverURL(restConfig.Host), k8sClient, routeClient), nil
+}
+
+func (c *blockedCluster) Inspect(ctx context.Context) error {
+	var err error
+
+	c.Sources = map[string][]AddressSource{}
+	c.RouteHosts = map[string][]string{}
+
+	c.NodeAddresses, err = c.findNodesAddresses(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.APIServerAddresses, err = c.findAPIServerAddress(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.RouteAddresses, err = c.findRouteAddresses(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllAddresses return sorted list of uniqe cluster address that must be blocked
+// on the target cluster.
+func (c *blockedCluster) AllAddresses() []string {
+	return c.Addresses(AllCategories...)
+}
+
+// Addresses returns sorted list of unique cluster addresses in categories.
+func (c *blockedCluster) Addresses(categories ...AddressCategory) []string {
+	res := sets.New[string]()
+	for _, category := range categories {
+		res.Insert(c.CategoryAddresses(category)...)
+	}
+	return sets.List(res)
+}
+
+// CategoryAddresses returns the cluster addresses in category.
+func (c *blockedCluster) CategoryAddresses(category AddressCategory) []string {
+	switch category {
+	case CategoryAPI:
+		return c.APIServerAddresses
+	case CategoryNodes:
+		return c.NodeAddresses
+	case CategoryRoutes:
+		return c.RouteAddresses
+	default:
+		return nil
+	}
+}
+
+// addSource adds a source to address, ignoring duplicate sources.
+func (c *blockedCluster) addSource(address string, category AddressCategory, name string) {
+	source := AddressSource{Category: category, Name: name}
+	for _, existing := range c.Sources[address] {
+		if existing == source {
+			return
+		}
+	}
+	c.Sources[address] = append(c.Sources[address], source)
+}
+
+// addRouteHost adds a route host to address, ignoring duplicate hosts.
+func (c *blockedCluster) addRouteHost(address string, host string) {
+	for _, existing := range c.RouteHosts[address] {
+		if existing == host {
+			return
+		}
+	}
+	c.RouteHosts[address] = append(c.RouteHosts[address], host)
+}
+
+func (c *blockedCluster) findNodesAddresses(ctx context.Context) ([]string, error) {
+	nodes, err := c.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+
+	for _, node := range nodes.Items {
+		address, err := externalIP(&node)
+		if err != nil {
+			return nil, err
+		}
+
+		dbglog(ctx).Printf("found node %s address %s", node.Name, address)
+		res = append(res, address)
+		c.addSource(address, CategoryNodes, node.Name)
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("could not find any node address")
+	}
+
+	return res, nil
+}
+
+func externalIP(node *apiv1.Node) (string, error) {
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == "ExternalIP" {
+			return addr.Address, nil
+		}
+	}
+	return "", fmt.Errorf("could not find external IP address for node %s", node.Name)
+}
+
+func (c *blockedCluster) findAPIServerAddress(ctx context.Context) ([]string, error) {
+	server, err := url.Parse(c.server)
+	if err != nil {
+		return nil, fmt.Errorf("cannnot parse cluster %q server URL %q",
+			c.Context, c.server)
+	}
+
+	port, err := serverPort(server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster %q server URL %q: %s",
+			c.Context, c.server, err)
+	}
+
+	c.APIServerHost = server.Hostname()
+	c.APIServerPort = port
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", server.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, ip := range ips {
+		dbglog(ctx).Printf("found api server %s address %s",
+			server.Hostname(), ip)
+		res = append(res, ip.String())
+		c.addSource(ip.String(), CategoryAPI, server.Hostname())
+	}
+
+	return res, nil
+}
+
+// serverURL returns the URL of a rest config host, which may be a host name
+// and port without a scheme.
+func serverURL(host string) string {
+	if strings.Contains(host, "://") {
+		return host
+	}
+	return "https://" + host
+}
+
+// serverPort returns the server URL port, or the default https port.
+func serverPort(server *url.URL) (uint16, error) {
+	if server.Port() == "" {
+		return 443, nil
+	}
+	port, err := strconv.ParseUint(server.Port(), 10, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(port), nil
+}
+
+func (c *blockedCluster) findRouteAddresses(ctx context.Context) ([]string, error) {
+	routes, err := c.routeClient.Routes("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	res := sets.New[string]()
+
+	for _, route := range routes.Items {
+		for i, ingress := range route.Status.Ingress {
+			if ingress.Host == "" {
+				dbglog(ctx).Printf("skipping route %s: ingress[%v]: host not available",
+					route.Name, i)
+				continue
+			}
+
+			ips, err := net.DefaultResolver.LookupIP(ctx, "ip", ingress.Host)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, ip := range ips {
+				dbglog(ctx).Printf("found route %s host %s address %s",
+					route.Name, ingress.Host, ip)
+				res.Insert(ip.String())
+				c.addSource(ip.String(), CategoryRoutes, route.Namespace+"/"+route.Name)
+				c.addRouteHost(ip.String(), ingress.Host)
+			}
+		}
+	}
+
+	return res.UnsortedList(), nil
+}
+
+// newTargetCluster returns a target cluster using the specified client. The
+// rest config is used by executors running commands in pods.
+func newTargetCluster(context string, restConfig *rest.Config, k8sClient kubernetes.Interface) *targetCluster {
+	return &targetCluster{
+		Context:    context,
+		restConfig: restConfig,
+		k8sClient:  k8sClient,
+	}
+}
+
+// loadTargetCluster returns a target cluster with a client created from the
+// context rest config.
+func loadTargetCluster(context string, restConfig *rest.Config) (*targetCluster, error) {
+	k8sClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTargetCluster(context, restConfig, k8sClient), nil
+}
+
+func (c *targetCluster) Inspect(ctx context.Context) error {
+	var err error
+
+	c.NodeNames, err = c.findNodeNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *targetCluster) findNodeNames(ctx context.Context) ([]string, error) {
+	var res []string
+
+	nodes, err := c.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes.Items {
+		res = append(res, node.Name)
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("could not find any node")
+	}
+
+	return res, nil
+}
+
+func createRestConfig(config *api.Config, context string) (*rest.Config, error) {
+	return clientcmd.NewNonInteractiveClientConfig(*config, context, nil, nil).ClientConfig()
+}
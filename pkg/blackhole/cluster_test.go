@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestBlockedClusterInspect(t *testing.T) {
+	cluster := newFakeBlockedCluster(
+		"blocked",
+		"https://10.0.0.100:6443",
+		map[string]string{
+			"node-1": "10.0.0.1",
+			"node-2": "10.0.0.2",
+		},
+		[]fakeRoute{
+			{Name: "s3", Hosts: []string{"10.0.0.200"}},
+			{Name: "console", Hosts: []string{"10.0.0.200", "fd00::200"}},
+			{Name: "pending", Hosts: []string{""}},
+		},
+	)
+
+	if err := cluster.Inspect(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	checkAddresses(t, "node", cluster.NodeAddresses, []string{"10.0.0.1", "10.0.0.2"})
+	checkAddresses(t, "api server", cluster.APIServerAddresses, []string{"10.0.0.100"})
+	checkAddresses(t, "route", cluster.RouteAddresses, []string{"10.0.0.200", "fd00::200"})
+
+	expected := map[string][]AddressSource{
+		"10.0.0.1":   {{Category: CategoryNodes, Name: "node-1"}},
+		"10.0.0.2":   {{Category: CategoryNodes, Name: "node-2"}},
+		"10.0.0.100": {{Category: CategoryAPI, Name: "10.0.0.100"}},
+		"10.0.0.200": {
+			{Category: CategoryRoutes, Name: "default/console"},
+			{Category: CategoryRoutes, Name: "default/s3"},
+		},
+		"fd00::200": {{Category: CategoryRoutes, Name: "default/console"}},
+	}
+	if !reflect.DeepEqual(cluster.Sources, expected) {
+		t.Errorf("expected sources %v, got %v", expected, cluster.Sources)
+	}
+
+	if cluster.APIServerHost != "10.0.0.100" || cluster.APIServerPort != 6443 {
+		t.Errorf("expected api server 10.0.0.100:6443, got %s:%d",
+			cluster.APIServerHost, cluster.APIServerPort)
+	}
+
+	routeHosts := map[string][]string{
+		"10.0.0.200": {"10.0.0.200"},
+		"fd00::200":  {"fd00::200"},
+	}
+	if !reflect.DeepEqual(cluster.RouteHosts, routeHosts) {
+		t.Errorf("expected route hosts %v, got %v", routeHosts, cluster.RouteHosts)
+	}
+}
+
+func TestBlockedClusterInspectNoExternalIP(t *testing.T) {
+	cluster := newFakeBlockedCluster(
+		"blocked",
+		"https://10.0.0.100:6443",
+		map[string]string{"node-1": ""},
+		nil,
+	)
+
+	if err := cluster.Inspect(context.TODO()); err == nil {
+		t.Fatal("inspecting node without external IP did not fail")
+	}
+}
+
+func TestBlockedClusterInspectNoNodes(t *testing.T) {
+	cluster := newFakeBlockedCluster("blocked", "https://10.0.0.100:6443", nil, nil)
+
+	if err := cluster.Inspect(context.TODO()); err == nil {
+		t.Fatal("inspecting cluster without nodes did not fail")
+	}
+}
+
+func TestBlockedClusterInspectInvalidServer(t *testing.T) {
+	cluster := newFakeBlockedCluster(
+		"blocked",
+		"https://10.0.0.100:invalid",
+		map[string]string{"node-1": "10.0.0.1"},
+		nil,
+	)
+
+	if err := cluster.Inspect(context.TODO()); err == nil {
+		t.Fatal("inspecting cluster with invalid server did not fail")
+	}
+}
+
+func TestServerURL(t *testing.T) {
+	cases := map[string]string{
+		"https://api.example.com:6443": "https://api.example.com:6443",
+		"api.example.com:6443":         "https://api.example.com:6443",
+		"http://10.0.0.1":              "http://10.0.0.1",
+	}
+	for host, expected := range cases {
+		if url := serverURL(host); url != expected {
+			t.Errorf("expected %q for %q, got %q", expected, host, url)
+		}
+	}
+}
+
+func TestBlockedClusterAllAddresses(t *testing.T) {
+	cluster := &blockedCluster{
+		NodeAddresses:      []string{"10.0.0.2", "10.0.0.1"},
+		APIServerAddresses: []string{"10.0.0.1"},
+		RouteAddresses:     []string{"10.0.0.3", "10.0.0.2"},
+	}
+
+	expected := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	if addresses := cluster.AllAddresses(); !reflect.DeepEqual(addresses, expected) {
+		t.Fatalf("expected addresses %v, got %v", expected, addresses)
+	}
+}
+
+func TestBlockedClusterAddresses(t *testing.T) {
+	cluster := &blockedCluster{
+		NodeAddresses:      []string{"10.0.0.2", "10.0.0.1"},
+		APIServerAddresses: []string{"10.0.0.100"},
+		RouteAddresses:     []string{"10.0.0.200"},
+	}
+
+	expected := []string{"10.0.0.200"}
+	if addresses := cluster.Addresses(CategoryRoutes); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected addresses %v, got %v", expected, addresses)
+	}
+
+	expected = []string{"10.0.0.1", "10.0.0.100", "10.0.0.2"}
+	if addresses := cluster.Addresses(CategoryNodes, CategoryAPI); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected addresses %v, got %v", expected, addresses)
+	}
+}
+
+func TestParseCategories(t *testing.T) {
+	categories, err := ParseCategories(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(categories, AllCategories) {
+		t.Errorf("expected categories %v, got %v", AllCategories, categories)
+	}
+
+	categories, err = ParseCategories([]string{"routes", "api", "routes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []AddressCategory{CategoryRoutes, CategoryAPI}
+	if !reflect.DeepEqual(categories, expected) {
+		t.Errorf("expected categories %v, got %v", expected, categories)
+	}
+
+	if categories, err := ParseCategories([]string{"ingress"}); err == nil {
+		t.Errorf("parsing unknown category did not fail: %v", categories)
+	}
+}
+
+func TestTargetClusterInspect(t *testing.T) {
+	cluster := newFakeTargetCluster("hub", "hub-2", "hub-1")
+
+	if err := cluster.Inspect(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	checkAddresses(t, "node names", cluster.NodeNames, []string{"hub-1", "hub-2"})
+}
+
+func TestTargetClusterInspectNoNodes(t *testing.T) {
+	cluster := newFakeTargetCluster("hub")
+
+	if err := cluster.Inspect(context.TODO()); err == nil {
+		t.Fatal("inspecting cluster without nodes did not fail")
+	}
+}
+
+func checkAddresses(t *testing.T, name string, actual []string, expected []string) {
+	t.Helper()
+	sorted := append([]string{}, actual...)
+	sort.Strings(sorted)
+	if !reflect.DeepEqual(sorted, expected) {
+		t.Fatalf("expected %s %v, got %v", name, expected, sorted)
+	}
+}
@@ -0,0 +1,841 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/rest"
+)
+
+type BlackholeStatus string
+
+const (
+	// None of cluster addresses are blocked in target cluster.
+	StatusUnblocked = BlackholeStatus("unblocked")
+
+	// All cluster addresses are blocked in target cluster.
+	StatusBlocked = BlackholeStatus("blocked")
+
+	// Some of cluster addresses are blocked in target cluster.
+	StatusPartlyBlocked = BlackholeStatus("partly-blocked")
+)
+
+// blockOptions describe how a cluster is blocked.
+type blockOptions struct {
+	// How to block the cluster addresses.
+	Method BlockMethod
+
+	// Block only traffic matching the filter. Requires a nft method.
+	Filter PortFilter
+
+	// If not zero, the target nodes unblock the cluster after Expire, even if
+	// we are not running.
+	Expire time.Duration
+
+	// If true, nodes blocked before a failure are kept blocked.
+	NoRollback bool
+}
+
+type NetworkStatus string
+
+const (
+	// Network to cluster addresses is not degraded in target cluster.
+	StatusNormal = NetworkStatus("normal")
+
+	// Network to all cluster addresses is degraded in target cluster.
+	StatusDegraded = NetworkStatus("degraded")
+
+	// Network to some of cluster addresses is degraded in target cluster.
+	StatusPartlyDegraded = NetworkStatus("partly-degraded")
+)
+
+type clusterStatus struct {
+	Valid   bool
+	Nodes   map[string]BlackholeStatus
+	Network map[string]NetworkStatus
+
+	// Status of every address category per node.
+	Categories map[string]map[AddressCategory]BlackholeStatus
+
+	// Cluster addresses with blackhole routes installed by others per node.
+	Foreign map[string][]string
+
+	// Number of inspected cluster addresses.
+	AddressCount int
+
+	// Number of blocked cluster addresses per node.
+	BlockedCount map[string]int
+
+	// Blocked and missing addresses per node.
+	Details map[string]*NodeDetails
+}
+
+// NodeDetails describes which cluster addresses are blocked on a node.
+type NodeDetails struct {
+	Blocked []AddressDetail `json:"blocked"`
+	Missing []AddressDetail `json:"missing"`
+}
+
+// AddressDetail describes a cluster address.
+type AddressDetail struct {
+	// The address, or address with protocol and port like
+	// "10.0.0.1 . tcp . 6443" when using a port filter.
+	Address string `json:"address"`
+
+	// Where the address was found.
+	Sources []AddressSource `json:"sources"`
+}
+
+type command struct {
+	Cluster *blockedCluster
+	Targets []*targetCluster
+
+	// The cluster address categories to modify or inspect. If empty, use all
+	// categories.
+	only []AddressCategory
+
+	executor nodeExecutor
+	pool     *nodePool
+	progress *progressReporter
+
+	// Delays when watching the cluster, see WatchCluster.
+	watchDelay time.Duration
+	watchRetry time.Duration
+}
+
+// newCommand returns a command for modifying or inspecting the blocked
+// cluster in the options targets.
+func newCommand(blockedContext string, options *Options) (*command, error) {
+	var err error
+
+	progress := options.progress()
+
+	defer func() {
+		if err != nil {
+			progress.Clear()
+		}
+	}()
+
+	err = validateContexts(blockedContext, options.Targets)
+	if err != nil {
+		return nil, err
+	}
+
+	configs, err := options.restConfigs(append([]string{blockedContext}, options.Targets...))
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := loadBlockedCluster(blockedContext, configs[blockedContext])
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := newTargetClusters(configs, options.Targets)
+	if err != nil {
+		return nil, err
+	}
+
+	executor, err := newExecutor(options, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	categories := options.Categories
+	if len(categories) == 0 {
+		categories = AllCategories
+	}
+
+	c := &command{
+		Cluster:    cluster,
+		Targets:    targets,
+		only:       categories,
+		executor:   executor,
+		pool:       options.pool(),
+		progress:   progress,
+		watchDelay: defaultWatchDelay,
+		watchRetry: defaultWatchRetry,
+	}
+	return c, nil
+}
+
+// loadTargets returns the options target clusters, for commands that do not
+// need a blocked cluster.
+func loadTargets(options *Options) ([]*targetCluster, error) {
+	if len(options.Targets) == 0 {
+		return nil, fmt.Errorf("no target contexts specified")
+	}
+
+	if err := validateContexts("", options.Targets); err != nil {
+		return nil, err
+	}
+
+	configs, err := options.restConfigs(options.Targets)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTargetClusters(configs, options.Targets)
+}
+
+func newTargetClusters(configs map[string]*rest.Config, targetContexts []string) ([]*targetCluster, error) {
+	var targets []*targetCluster
+	for _, target := range targetContexts {
+		target, err := loadTargetCluster(target, configs[target])
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// addresses returns the cluster addresses in the command categories.
+func (c *command) addresses() []string {
+	if len(c.only) == 0 {
+		return c.Cluster.AllAddresses()
+	}
+	return c.Cluster.Addresses(c.only...)
+}
+
+// categories returns the command categories.
+func (c *command) categories() []AddressCategory {
+	if len(c.only) == 0 {
+		return AllCategories
+	}
+	return c.only
+}
+
+// categoryAddresses returns the cluster addresses of every command category.
+func (c *command) categoryAddresses() map[AddressCategory][]string {
+	res := map[AddressCategory][]string{}
+	for _, category := range c.categories() {
+		res[category] = c.Cluster.CategoryAddresses(category)
+	}
+	return res
+}
+
+// inspectTargets inspects only the target clusters.
+func (c *command) inspectTargets(ctx context.Context) error {
+	errors := make(chan error)
+
+	for i := range c.Targets {
+		target := c.Targets[i]
+		go func() {
+			dbglog(ctx).Printf("Inspecting target %q ...", target.Context)
+			errors <- target.Inspect(ctx)
+		}()
+	}
+
+	return collectErrors(errors, len(c.Targets))
+}
+
+func (c *command) inspectClusters(ctx context.Context) error {
+	errors := make(chan error)
+
+	go func() {
+		dbglog(ctx).Printf("Inspecting cluster %q ...", c.Cluster.Context)
+		errors <- c.Cluster.Inspect(ctx)
+	}()
+
+	for i := range c.Targets {
+		target := c.Targets[i]
+		go func() {
+			dbglog(ctx).Printf("Inspecting target %q ...", target.Context)
+			errors <- target.Inspect(ctx)
+		}()
+	}
+
+	return collectErrors(errors, len(c.Targets)+1)
+}
+
+// BlockCluster blocks the cluster in all target nodes.
+func (c *command) BlockCluster(ctx context.Context, options *blockOptions) error {
+	_, err := c.blockCluster(ctx, options)
+	return err
+}
+
+// blockCluster blocks the cluster in all target nodes, and returns the outcome
+// of every node.
+func (c *command) blockCluster(ctx context.Context, options *blockOptions) ([]NodeOutcome, error) {
+	outcomes, _, err := c.blockClusterWithUndo(ctx, options)
+	return outcomes, err
+}
+
+// blockUndo undoes a successful block, restoring the nodes and the records to
+// their state before blocking.
+type blockUndo func(ctx context.Context) error
+
+// blockClusterWithUndo blocks the cluster like blockCluster, and returns a
+// function undoing the block. The function is nil if options.NoRollback is
+// set.
+func (c *command) blockClusterWithUndo(ctx context.Context, options *blockOptions) ([]NodeOutcome, blockUndo, error) {
+	if err := checkMethodFilter(options.Method, options.Filter); err != nil {
+		return nil, nil, err
+	}
+
+	defer c.progress.Clear()
+
+	c.progress.SetDescription("inspecting clusters")
+
+	if err := c.inspectClusters(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	addresses := c.addresses()
+	unit := expiryUnit(c.Cluster.Context)
+
+	// Keep the previous records, so we can restore them on rollback.
+	records, err := c.readRecords(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Record the addresses before modifying the nodes, so we can unblock
+	// them if blocking fails in the middle.
+	if err := c.recordBlock(ctx, options.Method, options.Expire, false); err != nil {
+		return nil, nil, err
+	}
+
+	// Node states before blocking, inspected by the block script, used for
+	// rollback.
+	var mutex sync.Mutex
+	states := map[string]*nodeState{}
+
+	outcomes := c.runNodes(ctx, "Blocking", "blocked", func(target *targetCluster, nodeName string) error {
+		if options.NoRollback {
+			return blockNode(ctx, c.executor, target.Context, nodeName,
+				options.Method, addresses, options.Filter, unit, options.Expire)
+		}
+		state, err := blockNodeWithState(ctx, c.executor, target.Context, nodeName,
+			options.Method, addresses, options.Filter, unit, options.Expire)
+		if state != nil {
+			mutex.Lock()
+			states[target.Context+"/"+nodeName] = state
+			mutex.Unlock()
+		}
+		return err
+	})
+
+	if !failed(outcomes) {
+		if options.NoRollback {
+			return outcomes, nil, nil
+		}
+		undo := func(ctx context.Context) error {
+			return c.undoBlock(ctx, outcomes, states, records, options, addresses)
+		}
+		return outcomes, undo, nil
+	}
+
+	blockErr := &BlockError{Cluster: c.Cluster.Context, Outcomes: outcomes}
+
+	if options.NoRollback {
+		return nil, nil, blockErr
+	}
+
+	blockErr.RolledBack = true
+
+	if err := c.rollbackBlock(ctx, outcomes, states, records, options, addresses); err != nil {
+		return nil, nil, fmt.Errorf("%s; restoring records failed: %s", blockErr, err)
+	}
+
+	return nil, nil, blockErr
+}
+
+// UnblockCluster unblocks traffic matching filter to the cluster in all target
+// nodes. If filter is empty, all traffic is unblocked.
+func (c *command) UnblockCluster(ctx context.Context, filter PortFilter) error {
+	_, err := c.unblockCluster(ctx, filter)
+	return err
+}
+
+// unblockCluster unblocks traffic matching filter to the cluster in all target
+// nodes, and returns the outcome of every node.
+func (c *command) unblockCluster(ctx context.Context, filter PortFilter) ([]NodeOutcome, error) {
+	defer c.progress.Clear()
+
+	c.progress.SetDescription("inspecting clusters")
+
+	// We unblock the addresses recorded when blocking the cluster, so we
+	// don't need to inspect the blocked cluster.
+	if err := c.inspectTargets(ctx); err != nil {
+		return nil, err
+	}
+
+	records, err := c.readRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := c.unblockAddresses(ctx, records)
+	if err != nil {
+		return nil, err
+	}
+
+	unit := expiryUnit(c.Cluster.Context)
+
+	outcomes, err := c.modifyNodes(ctx, "Unblocking", "unblocked", func(target *targetCluster, nodeName string) error {
+		// Older versions added unmarked routes, and the first releases did
+		// not write a record.
+		record, ok := records[target.Context]
+		legacy := !ok || record.unmarkedRoutes()
+		return unblockNode(ctx, c.executor, target.Context, nodeName,
+			addresses[target.Context], filter, unit, legacy)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Other ports or protocols may be still blocked.
+	if !filter.IsEmpty() {
+		return outcomes, nil
+	}
+
+	if err := c.removeRecords(ctx, records); err != nil {
+		return nil, err
+	}
+
+	return outcomes, nil
+}
+
+// unblockAddresses returns the addresses to unblock in every target. If a
+// target has no record, for example if the cluster was blocked by an older
+// version, we use the current blocked cluster addresses.
+func (c *command) unblockAddresses(ctx context.Context, records map[string]*blockRecord) (map[string][]string, error) {
+	res := map[string][]string{}
+	inspected := false
+
+	for _, target := range c.Targets {
+		if record, ok := records[target.Context]; ok {
+			res[target.Context] = record.CategoryAddresses(c.categories())
+			continue
+		}
+
+		if !inspected {
+			dbglog(ctx).Printf("No record in target %q, inspecting cluster %q ...",
+				target.Context, c.Cluster.Context)
+			if err := c.Cluster.Inspect(ctx); err != nil {
+				return nil, err
+			}
+			inspected = true
+		}
+
+		res[target.Context] = c.addresses()
+	}
+
+	return res, nil
+}
+
+// recordBlock adds the blocked addresses to the records in all targets. If
+// replace is true, the recorded addresses of the command categories are
+// replaced. If expire is not zero, the records expire with the block. The
+// nodes are blocked after recording, so the records expire ExpiryGrace after
+// the block, to allow blocking all nodes.
+func (c *command) recordBlock(ctx context.Context, method BlockMethod, expire time.Duration, replace bool) error {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	var expires *time.Time
+	if expire > 0 {
+		t := now.Add(expire + ExpiryGrace)
+		expires = &t
+	}
+
+	for _, target := range c.Targets {
+		record, err := readRecord(ctx, target, c.Cluster.Context)
+		if err != nil {
+			return err
+		}
+
+		// Existing records keep the route protocol, so unblocking removes
+		// unmarked routes added by older versions.
+		if record == nil {
+			record = &blockRecord{Cluster: c.Cluster.Context, RouteProto: routeProto}
+		}
+
+		if replace {
+			record.Set(c.categoryAddresses())
+		} else {
+			record.Add(c.categoryAddresses())
+		}
+		record.Method = method
+		record.Time = now
+		record.Expires = expires
+		record.User = currentUser()
+
+		if err := writeRecord(ctx, target, record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readRecords returns the records found in the targets.
+func (c *command) readRecords(ctx context.Context) (map[string]*blockRecord, error) {
+	res := map[string]*blockRecord{}
+
+	for _, target := range c.Targets {
+		record, err := readRecord(ctx, target, c.Cluster.Context)
+		if err != nil {
+			return nil, err
+		}
+
+		if record != nil {
+			res[target.Context] = record
+		}
+	}
+
+	return res, nil
+}
+
+// removeRecords removes the unblocked categories from the records, deleting
+// empty records.
+func (c *command) removeRecords(ctx context.Context, records map[string]*blockRecord) error {
+	for _, target := range c.Targets {
+		record, ok := records[target.Context]
+		if !ok {
+			continue
+		}
+
+		record.Remove(c.categories())
+
+		var err error
+		if record.IsEmpty() {
+			err = deleteRecord(ctx, target, c.Cluster.Context)
+		} else {
+			err = writeRecord(ctx, target, record)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DegradeCluster degrades the network to the cluster in all target nodes.
+func (c *command) DegradeCluster(ctx context.Context, options *NetemOptions) error {
+	netem, err := options.Args()
+	if err != nil {
+		return err
+	}
+
+	defer c.progress.Clear()
+
+	c.progress.SetDescription("inspecting clusters")
+
+	if err := c.inspectClusters(ctx); err != nil {
+		return err
+	}
+
+	addresses := c.addresses()
+
+	_, err = c.modifyNodes(ctx, "Degrading", "degraded", func(target *targetCluster, nodeName string) error {
+		return degradeNetwork(ctx, c.executor, target.Context, nodeName,
+			addresses, netem)
+	})
+	return err
+}
+
+// RestoreCluster removes the network degradation to the cluster in all target
+// nodes.
+func (c *command) RestoreCluster(ctx context.Context) error {
+	defer c.progress.Clear()
+
+	c.progress.SetDescription("inspecting clusters")
+
+	if err := c.inspectClusters(ctx); err != nil {
+		return err
+	}
+
+	addresses := c.addresses()
+
+	_, err := c.modifyNodes(ctx, "Restoring", "restored", func(target *targetCluster, nodeName string) error {
+		return restoreNetwork(ctx, c.executor, target.Context, nodeName, addresses)
+	})
+	return err
+}
+
+// VerifyCluster runs probes matching filter from all target nodes to the
+// cluster addresses, and returns the results. Use Verification.Err to check if
+// the cluster has the expected status.
+func (c *command) VerifyCluster(ctx context.Context, expected BlackholeStatus, filter PortFilter) (*Verification, error) {
+	defer c.progress.Clear()
+
+	c.progress.SetDescription("inspecting clusters")
+
+	if err := c.inspectClusters(ctx); err != nil {
+		return nil, err
+	}
+
+	probes := c.Cluster.Probes(c.categories(), filter)
+	if len(probes) == 0 {
+		return nil, fmt.Errorf("no probes for cluster %q addresses (%s)", c.Cluster.Context, filter)
+	}
+
+	// Reachable probes per "target/node".
+	var mutex sync.Mutex
+	reachable := map[string]sets.Set[string]{}
+
+	outcomes := c.runNodes(ctx, "Verifying", "verified", func(target *targetCluster, nodeName string) error {
+		res, err := probeNode(ctx, c.executor, target.Context, nodeName, probes)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		reachable[target.Context+"/"+nodeName] = res
+		mutex.Unlock()
+		return nil
+	})
+
+	if failed(outcomes) {
+		return nil, &NodesError{Operation: fmt.Sprintf("verifying cluster %q", c.Cluster.Context), Outcomes: outcomes}
+	}
+
+	verification := &Verification{Expected: expected}
+
+	for _, outcome := range outcomes {
+		for _, probe := range probes {
+			verification.Results = append(verification.Results, ProbeResult{
+				Target:    outcome.Target,
+				Node:      outcome.Node,
+				Probe:     probe,
+				Reachable: reachable[outcome.Target+"/"+outcome.Node].Has(probe.key()),
+			})
+		}
+	}
+
+	sortResults(verification.Results)
+
+	return verification, nil
+}
+
+// modifyNodes runs fn concurrently on all target nodes, and returns the
+// outcome of every node, or a *NodesError if some nodes failed.
+func (c *command) modifyNodes(ctx context.Context, action string, done string, fn func(*targetCluster, string) error) ([]NodeOutcome, error) {
+	outcomes := c.runNodes(ctx, action, done, fn)
+	if failed(outcomes) {
+		operation := fmt.Sprintf("%s cluster %q", strings.ToLower(action), c.Cluster.Context)
+		return nil, &NodesError{Operation: operation, Outcomes: outcomes}
+	}
+	return outcomes, nil
+}
+
+// runNodes runs fn concurrently on all target nodes, waits until all nodes
+// are done, and returns the outcome of every node, ordered by target and
+// node.
+func (c *command) runNodes(ctx context.Context, action string, done string, fn func(*targetCluster, string) error) []NodeOutcome {
+	c.progress.SetTasks(uint(c.targetNodeCount()))
+	c.progress.SetDescription(strings.ToLower(action) + " nodes")
+
+	for _, target := range c.Targets {
+		dbglog(ctx).Printf("%s cluster %q in target %q ...", action, c.Cluster.Context, target.Context)
+	}
+
+	return runTargets(ctx, c.pool, c.progress, c.Targets, func(target *targetCluster, nodeName string) error {
+		if err := fn(target, nodeName); err != nil {
+			return err
+		}
+		dbglog(ctx).Printf("Cluster %q %s in node %q", c.Cluster.Context, done, nodeName)
+		return nil
+	})
+}
+
+// modifyTargets runs fn concurrently on all nodes of the inspected targets,
+// and returns a *NodesError if some nodes failed.
+func modifyTargets(ctx context.Context, pool *nodePool, operation string, targets []*targetCluster, fn func(*targetCluster, string) error) error {
+	progress := newProgressReporter("", 0, io.Discard)
+	outcomes := runTargets(ctx, pool, progress, targets, fn)
+	if failed(outcomes) {
+		return &NodesError{Operation: operation, Outcomes: outcomes}
+	}
+	return nil
+}
+
+type nodeResult struct {
+	Context string
+	Node    string
+	State   *nodeState
+	Err     error
+}
+
+// clusterStatus returns the status of traffic matching filter to the cluster
+// in all target nodes.
+func (c *command) clusterStatus(ctx context.Context, filter PortFilter) (map[string]*clusterStatus, error) {
+	defer c.progress.Clear()
+
+	c.progress.SetDescription("inspecting clusters")
+
+	if err := c.inspectClusters(ctx); err != nil {
+		return nil, err
+	}
+
+	var mutex sync.Mutex
+	var results []*nodeResult
+
+	outcomes := c.runNodes(ctx, "Inspecting", "inspected", func(target *targetCluster, nodeName string) error {
+		state, err := inspectNode(ctx, c.executor, target.Context, nodeName)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		results = append(results, &nodeResult{Context: target.Context, Node: nodeName, State: state})
+		mutex.Unlock()
+		return nil
+	})
+
+	if failed(outcomes) {
+		return nil, &NodesError{Operation: fmt.Sprintf("inspecting cluster %q", c.Cluster.Context), Outcomes: outcomes}
+	}
+
+	return c.collectResults(results, filter), nil
+}
+
+func (c *command) collectResults(results []*nodeResult, filter PortFilter) map[string]*clusterStatus {
+	res := map[string]*clusterStatus{}
+
+	for _, target := range c.Targets {
+		res[target.Context] = &clusterStatus{
+			Valid:      true,
+			Nodes:      map[string]BlackholeStatus{},
+			Network:    map[string]NetworkStatus{},
+			Categories: map[string]map[AddressCategory]BlackholeStatus{},
+			Foreign:    map[string][]string{},
+		}
+	}
+
+	addresses := c.addresses()
+	elements := filter.Elements(addresses)
+
+	categoryElements := map[AddressCategory][]string{}
+	for _, category := range c.categories() {
+		categoryElements[category] = filter.Elements(c.Cluster.CategoryAddresses(category))
+	}
+
+	for _, status := range res {
+		status.AddressCount = len(elements)
+		status.BlockedCount = map[string]int{}
+		status.Details = map[string]*NodeDetails{}
+	}
+
+	for _, result := range results {
+		status := res[result.Context]
+		nodeBlocked := result.State.Blocked()
+		status.BlockedCount[result.Node] = blockedCount(elements, nodeBlocked)
+		status.Details[result.Node] = c.nodeDetails(elements, nodeBlocked)
+
+		newStatus := blackholeStatus(elements, nodeBlocked)
+		if newStatus == StatusPartlyBlocked {
+			status.Valid = false
+		}
+
+		if lastStatus, ok := status.Nodes[result.Node]; ok {
+			if lastStatus != newStatus {
+				status.Valid = false
+			}
+		}
+
+		status.Nodes[result.Node] = newStatus
+
+		categories := map[AddressCategory]BlackholeStatus{}
+		for category, elements := range categoryElements {
+			categories[category] = blackholeStatus(elements, nodeBlocked)
+		}
+		status.Categories[result.Node] = categories
+
+		if foreign := filterAddresses(addresses, result.State.ForeignRoutes); len(foreign) > 0 {
+			status.Foreign[result.Node] = foreign
+		}
+
+		if result.State.Degraded.HasAll(addresses...) {
+			status.Network[result.Node] = StatusDegraded
+		} else if result.State.Degraded.HasAny(addresses...) {
+			status.Network[result.Node] = StatusPartlyDegraded
+		} else {
+			status.Network[result.Node] = StatusNormal
+		}
+	}
+
+	return res
+}
+
+// blackholeStatus returns the status of elements blocked by blocked
+// elements.
+func blackholeStatus(elements []string, blocked sets.Set[string]) BlackholeStatus {
+	count := blockedCount(elements, blocked)
+
+	switch {
+	case count == 0:
+		return StatusUnblocked
+	case count == len(elements):
+		return StatusBlocked
+	default:
+		return StatusPartlyBlocked
+	}
+}
+
+// nodeDetails returns the blocked and missing elements on a node.
+func (c *command) nodeDetails(elements []string, blocked sets.Set[string]) *NodeDetails {
+	details := &NodeDetails{
+		Blocked: []AddressDetail{},
+		Missing: []AddressDetail{},
+	}
+
+	for _, element := range elements {
+		detail := AddressDetail{
+			Address: element,
+			Sources: c.Cluster.Sources[elementAddress(element)],
+		}
+		if elementBlocked(element, blocked) {
+			details.Blocked = append(details.Blocked, detail)
+		} else {
+			details.Missing = append(details.Missing, detail)
+		}
+	}
+
+	return details
+}
+
+// blockedCount returns the number of elements blocked by blocked elements.
+func blockedCount(elements []string, blocked sets.Set[string]) int {
+	count := 0
+	for _, element := range elements {
+		if elementBlocked(element, blocked) {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *command) targetNodeCount() int {
+	count := 0
+	for _, target := range c.Targets {
+		count += len(target.NodeNames)
+	}
+	return count
+}
+
+func validateContexts(blockedContext string, targetContexts []string) error {
+	targets := sets.New(targetContexts...)
+
+	if len(targets) != len(targetContexts) {
+		return fmt.Errorf("duplicate contexts: %v", targetContexts)
+	}
+
+	if targets.Has(blockedContext) {
+		return fmt.Errorf("blocked cluster %q in target clusters %q",
+			blockedContext, targetContexts)
+	}
+
+	return nil
+}
@@ -0,0 +1,1041 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+var fakeClusterAddresses = []string{
+	"10.0.0.1",
+	"10.0.0.100",
+	"10.0.0.2",
+	"10.0.0.200",
+	"10.0.0.3",
+	"fd00::200",
+}
+
+func TestBlockCluster(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range c.Targets {
+		for _, node := range target.NodeNames {
+			routes := executor.Routes(target.Context, node)
+			if !routes.Equal(sets.New(fakeClusterAddresses...)) {
+				t.Errorf("expected %s/%s routes %v, got %v",
+					target.Context, node, fakeClusterAddresses, sets.List(routes))
+			}
+		}
+	}
+}
+
+func TestBlockClusterNft(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodNftDrop}); err != nil {
+		t.Fatal(err)
+	}
+
+	dropV4 := sets.New("10.0.0.1", "10.0.0.100", "10.0.0.2", "10.0.0.200", "10.0.0.3")
+	dropV6 := sets.New("fd00::200")
+
+	for _, target := range c.Targets {
+		for _, node := range target.NodeNames {
+			if routes := executor.Routes(target.Context, node); routes.Len() != 0 {
+				t.Errorf("unexpected %s/%s routes %v", target.Context, node, sets.List(routes))
+			}
+			if set := executor.NftSet(target.Context, node, "drop_v4"); !set.Equal(dropV4) {
+				t.Errorf("expected %s/%s drop_v4 %v, got %v",
+					target.Context, node, sets.List(dropV4), sets.List(set))
+			}
+			if set := executor.NftSet(target.Context, node, "drop_v6"); !set.Equal(dropV6) {
+				t.Errorf("expected %s/%s drop_v6 %v, got %v",
+					target.Context, node, sets.List(dropV6), sets.List(set))
+			}
+		}
+	}
+
+	checkAllNodes(t, c, PortFilter{}, StatusBlocked)
+
+	// Switching to reject moves the addresses to the reject sets.
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodNftReject}); err != nil {
+		t.Fatal(err)
+	}
+
+	if set := executor.NftSet("hub", "hub-1", "drop_v4"); set.Len() != 0 {
+		t.Errorf("addresses not removed from drop_v4: %v", sets.List(set))
+	}
+	if set := executor.NftSet("hub", "hub-1", "reject_v4"); !set.Equal(dropV4) {
+		t.Errorf("expected reject_v4 %v, got %v", sets.List(dropV4), sets.List(set))
+	}
+
+	checkAllNodes(t, c, PortFilter{}, StatusBlocked)
+
+	if err := c.UnblockCluster(context.TODO(), PortFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, set := range []string{"drop_v4", "drop_v6", "reject_v4", "reject_v6"} {
+		if elements := executor.NftSet("hub", "hub-1", set); elements.Len() != 0 {
+			t.Errorf("addresses not removed from %s: %v", set, sets.List(elements))
+		}
+	}
+
+	checkAllNodes(t, c, PortFilter{}, StatusUnblocked)
+}
+
+func TestBlockClusterUnknownMethod(t *testing.T) {
+	c := newFakeCommand(newFakeExecutor())
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: "unknown"}); err == nil {
+		t.Fatal("blocking with unknown method did not fail")
+	}
+}
+
+func TestBlockClusterPorts(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	api := PortFilter{Protocol: ProtocolTCP, Ports: []uint16{6443}}
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodNftReject, Filter: api}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := sets.New(
+		"10.0.0.1 . tcp . 6443",
+		"10.0.0.100 . tcp . 6443",
+		"10.0.0.2 . tcp . 6443",
+		"10.0.0.200 . tcp . 6443",
+		"10.0.0.3 . tcp . 6443",
+	)
+	if set := executor.NftSet("hub", "hub-1", "reject_v4_port"); !set.Equal(expected) {
+		t.Errorf("expected reject_v4_port %v, got %v", sets.List(expected), sets.List(set))
+	}
+	if set := executor.NftSet("hub", "hub-1", "reject_v4"); set.Len() != 0 {
+		t.Errorf("unexpected reject_v4 %v", sets.List(set))
+	}
+
+	// Only the blocked port is reported as blocked.
+	checkAllNodes(t, c, api, StatusBlocked)
+	checkAllNodes(t, c, PortFilter{Protocol: ProtocolTCP, Ports: []uint16{443}}, StatusUnblocked)
+	checkAllNodes(t, c, PortFilter{}, StatusUnblocked)
+
+	// Unblocking another port keeps the block.
+	if err := c.UnblockCluster(context.TODO(), PortFilter{Ports: []uint16{443}}); err != nil {
+		t.Fatal(err)
+	}
+	checkAllNodes(t, c, api, StatusBlocked)
+
+	if err := c.UnblockCluster(context.TODO(), api); err != nil {
+		t.Fatal(err)
+	}
+	checkAllNodes(t, c, api, StatusUnblocked)
+}
+
+func TestUnblockClusterAllPorts(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	options := &blockOptions{Method: MethodNftDrop, Filter: PortFilter{Ports: []uint16{6443, 443}}}
+	if err := c.BlockCluster(context.TODO(), options); err != nil {
+		t.Fatal(err)
+	}
+	options = &blockOptions{Method: MethodNftDrop, Filter: PortFilter{Protocol: ProtocolUDP}}
+	if err := c.BlockCluster(context.TODO(), options); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocking the protocol blocks all ports.
+	checkAllNodes(t, c, PortFilter{Protocol: ProtocolUDP, Ports: []uint16{53}}, StatusBlocked)
+
+	// Unblocking without a filter removes all blocks.
+	if err := c.UnblockCluster(context.TODO(), PortFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, set := range []string{"drop_v4_port", "drop_v6_port", "drop_v4_proto", "drop_v6_proto"} {
+		if elements := executor.NftSet("hub", "hub-1", set); elements.Len() != 0 {
+			t.Errorf("elements not removed from %s: %v", set, sets.List(elements))
+		}
+	}
+}
+
+func TestBlockClusterPortsWithRoute(t *testing.T) {
+	c := newFakeCommand(newFakeExecutor())
+
+	options := &blockOptions{Method: MethodRoute, Filter: PortFilter{Ports: []uint16{6443}}}
+	if err := c.BlockCluster(context.TODO(), options); err == nil {
+		t.Fatal("blocking ports with route method did not fail")
+	}
+}
+
+func TestBlockClusterCategories(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+	c.only = []AddressCategory{CategoryRoutes}
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := sets.New("10.0.0.200", "fd00::200")
+	if routes := executor.Routes("hub", "hub-1"); !routes.Equal(expected) {
+		t.Errorf("expected routes %v, got %v", sets.List(expected), sets.List(routes))
+	}
+
+	checkAllNodes(t, c, PortFilter{}, StatusBlocked)
+
+	// Show status of all categories.
+	c.only = AllCategories
+
+	status, err := c.clusterStatus(context.TODO(), PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkStatus(t, status, "hub", false, map[string]BlackholeStatus{
+		"hub-1": StatusPartlyBlocked,
+		"hub-2": StatusPartlyBlocked,
+	})
+
+	categories := map[AddressCategory]BlackholeStatus{
+		CategoryAPI:    StatusUnblocked,
+		CategoryNodes:  StatusUnblocked,
+		CategoryRoutes: StatusBlocked,
+	}
+	if res := status["hub"].Categories["hub-1"]; !reflect.DeepEqual(res, categories) {
+		t.Errorf("expected categories %v, got %v", categories, res)
+	}
+}
+
+func TestBlockClusterTwice(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlockClusterExpire(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute, Expire: 10 * time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"oc-blackhole-expire-blocked": "600s"}
+	if timers := executor.Timers("hub", "hub-1"); !reflect.DeepEqual(timers, expected) {
+		t.Fatalf("expected timers %v, got %v", expected, timers)
+	}
+
+	// Blocking again replaces the timer.
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute, Expire: 20 * time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = map[string]string{"oc-blackhole-expire-blocked": "1200s"}
+	if timers := executor.Timers("hub", "hub-1"); !reflect.DeepEqual(timers, expected) {
+		t.Fatalf("expected timers %v, got %v", expected, timers)
+	}
+
+	if err := c.UnblockCluster(context.TODO(), PortFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if timers := executor.Timers("hub", "hub-1"); len(timers) != 0 {
+		t.Fatalf("timers not cancelled: %v", timers)
+	}
+}
+
+func TestBlockClusterFailure(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.Fail("cluster2", "cluster2-2", errors.New("oc debug failed"))
+	c := newFakeCommand(executor)
+
+	err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute})
+	if err == nil {
+		t.Fatal("blocking with failing node did not fail")
+	}
+
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) {
+		t.Fatalf("unexpected error %T: %s", err, err)
+	}
+	if !blockErr.RolledBack {
+		t.Error("block was not rolled back")
+	}
+
+	for _, outcome := range blockErr.Outcomes {
+		expected := ResultRolledBack
+		if outcome.Node == "cluster2-2" {
+			expected = ResultFailed
+		}
+		if outcome.Result != expected {
+			t.Errorf("expected %s/%s result %q, got %q", outcome.Target, outcome.Node, expected, outcome.Result)
+		}
+		if routes := executor.Routes(outcome.Target, outcome.Node); routes.Len() != 0 {
+			t.Errorf("unexpected %s/%s routes %v", outcome.Target, outcome.Node, sets.List(routes))
+		}
+	}
+	if len(blockErr.Outcomes) != 5 {
+		t.Errorf("expected 5 outcomes, got %d", len(blockErr.Outcomes))
+	}
+
+	// The record created by the block was deleted in hub. The cluster2-2
+	// node failed before it was inspected and may be blocked, so the
+	// record is kept in cluster2.
+	for _, target := range c.Targets {
+		record, err := readRecord(context.TODO(), target, "blocked")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kept := record != nil; kept != (target.Context == "cluster2") {
+			t.Errorf("unexpected record in %s: %+v", target.Context, record)
+		}
+	}
+}
+
+func TestBlockClusterFailureAfterInspect(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.FailCommand("cluster2", "cluster2-2", "ip route replace blackhole 10.0.0.3 ")
+	c := newFakeCommand(executor)
+
+	err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute})
+
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) {
+		t.Fatalf("unexpected error %T: %v", err, err)
+	}
+
+	// The routes added before the failure were rolled back.
+	for _, outcome := range blockErr.Outcomes {
+		expected := ResultRolledBack
+		if outcome.Node == "cluster2-2" {
+			expected = ResultFailed
+		}
+		if outcome.Result != expected {
+			t.Errorf("expected %s/%s result %q, got %q", outcome.Target, outcome.Node, expected, outcome.Result)
+		}
+		if routes := executor.Routes(outcome.Target, outcome.Node); routes.Len() != 0 {
+			t.Errorf("unexpected %s/%s routes %v", outcome.Target, outcome.Node, sets.List(routes))
+		}
+	}
+
+	// All nodes were rolled back, so all records were deleted.
+	for _, target := range c.Targets {
+		record, err := readRecord(context.TODO(), target, "blocked")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record != nil {
+			t.Errorf("unexpected record in %s: %+v", target.Context, record)
+		}
+	}
+}
+
+func TestBlockClusterFailureNoRollback(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.Fail("cluster2", "cluster2-2", errors.New("oc debug failed"))
+	c := newFakeCommand(executor)
+
+	err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute, NoRollback: true})
+
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) {
+		t.Fatalf("unexpected error %T: %v", err, err)
+	}
+	if blockErr.RolledBack {
+		t.Error("block was rolled back")
+	}
+
+	if routes := executor.Routes("hub", "hub-1"); !routes.Equal(sets.New(fakeClusterAddresses...)) {
+		t.Errorf("expected hub/hub-1 routes %v, got %v", fakeClusterAddresses, sets.List(routes))
+	}
+
+	// The record is kept so we can unblock the blocked nodes.
+	record, err := readRecord(context.TODO(), c.Targets[0], "blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil {
+		t.Error("record was deleted")
+	}
+}
+
+func TestBlockClusterFailureKeepsPreviousBlock(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	c.only = []AddressCategory{CategoryAPI}
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodNftDrop}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Switching to reject and adding categories fails on one node.
+	executor.Fail("cluster2", "cluster2-2", errors.New("oc debug failed"))
+	c.only = AllCategories
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodNftReject}); err == nil {
+		t.Fatal("blocking with failing node did not fail")
+	}
+
+	// The previous block is restored.
+	for _, node := range []string{"hub-1", "hub-2"} {
+		if set := executor.NftSet("hub", node, "drop_v4"); !set.Equal(sets.New("10.0.0.100")) {
+			t.Errorf("expected hub/%s drop_v4 [10.0.0.100], got %v", node, sets.List(set))
+		}
+		if set := executor.NftSet("hub", node, "reject_v4"); set.Len() != 0 {
+			t.Errorf("unexpected hub/%s reject_v4 %v", node, sets.List(set))
+		}
+	}
+
+	record, err := readRecord(context.TODO(), c.Targets[0], "blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[AddressCategory][]string{CategoryAPI: {"10.0.0.100"}}
+	if record.Method != MethodNftDrop || !reflect.DeepEqual(record.Addresses, expected) {
+		t.Errorf("unexpected record %+v", record)
+	}
+}
+
+func TestUnblockCluster(t *testing.T) {
+	executor := newFakeExecutor()
+	// Foreign blackholes that must not be removed.
+	executor.AddForeignRoutes("hub", "hub-1", "192.168.1.1", "10.0.0.1")
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UnblockCluster(context.TODO(), PortFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range c.Targets {
+		for _, node := range target.NodeNames {
+			routes := executor.Routes(target.Context, node)
+			if routes.HasAny(fakeClusterAddresses...) {
+				t.Errorf("cluster addresses not removed from %s/%s: %v",
+					target.Context, node, sets.List(routes))
+			}
+		}
+	}
+
+	expected := sets.New("192.168.1.1", "10.0.0.1")
+	if foreign := executor.ForeignRoutes("hub", "hub-1"); !foreign.Equal(expected) {
+		t.Errorf("expected foreign blackholes %v, got %v", sets.List(expected), sets.List(foreign))
+	}
+}
+
+func TestUnblockClusterLegacyRoutes(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	// An older version added unmarked routes and recorded the addresses
+	// without a route protocol.
+	for _, target := range c.Targets {
+		record := &blockRecord{
+			Cluster:   "blocked",
+			Method:    MethodRoute,
+			Addresses: map[AddressCategory][]string{CategoryNodes: {"10.0.0.1", "10.0.0.2"}},
+		}
+		if err := writeRecord(context.TODO(), target, record); err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range target.NodeNames {
+			executor.AddForeignRoutes(target.Context, node, "10.0.0.1", "10.0.0.2")
+		}
+	}
+	// Foreign blackhole that must not be removed.
+	executor.AddForeignRoutes("hub", "hub-1", "192.168.1.1")
+
+	if err := c.UnblockCluster(context.TODO(), PortFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range c.Targets {
+		for _, node := range target.NodeNames {
+			expected := sets.New[string]()
+			if node == "hub-1" {
+				expected.Insert("192.168.1.1")
+			}
+			if foreign := executor.ForeignRoutes(target.Context, node); !foreign.Equal(expected) {
+				t.Errorf("expected %s/%s foreign blackholes %v, got %v",
+					target.Context, node, sets.List(expected), sets.List(foreign))
+			}
+		}
+	}
+}
+
+func TestUnblockClusterWithoutRecord(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	// The first releases added unmarked routes without a record.
+	for _, target := range c.Targets {
+		for _, node := range target.NodeNames {
+			executor.AddForeignRoutes(target.Context, node, "10.0.0.1", "10.0.0.2", "10.0.0.100")
+		}
+	}
+	// Foreign blackholes that must not be removed.
+	executor.AddForeignRoutes("hub", "hub-1", "192.168.1.1", "10.0.0.3 proto static")
+
+	if err := c.UnblockCluster(context.TODO(), PortFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range c.Targets {
+		for _, node := range target.NodeNames {
+			expected := sets.New[string]()
+			if node == "hub-1" {
+				expected.Insert("192.168.1.1", "10.0.0.3 proto static")
+			}
+			if foreign := executor.ForeignRoutes(target.Context, node); !foreign.Equal(expected) {
+				t.Errorf("expected %s/%s foreign blackholes %v, got %v",
+					target.Context, node, sets.List(expected), sets.List(foreign))
+			}
+		}
+	}
+}
+
+func TestClusterStatusForeignRoutes(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.AddForeignRoutes("hub", "hub-1", "192.168.1.1", "10.0.0.1")
+	c := newFakeCommand(executor)
+
+	status, err := c.clusterStatus(context.TODO(), PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Foreign blackholes are not our blocks.
+	checkStatus(t, status, "hub", true, map[string]BlackholeStatus{
+		"hub-1": StatusUnblocked,
+		"hub-2": StatusUnblocked,
+	})
+
+	// But foreign blackholes for cluster addresses are reported.
+	expected := []string{"10.0.0.1"}
+	if foreign := status["hub"].Foreign["hub-1"]; !reflect.DeepEqual(foreign, expected) {
+		t.Errorf("expected foreign blackholes %v, got %v", expected, foreign)
+	}
+	if foreign := status["hub"].Foreign["hub-2"]; len(foreign) != 0 {
+		t.Errorf("unexpected foreign blackholes %v", foreign)
+	}
+}
+
+func TestUnblockClusterFromRecord(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := readRecord(context.TODO(), c.Targets[0], "blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil {
+		t.Fatal("block not recorded")
+	}
+	if addresses := record.CategoryAddresses(AllCategories); !reflect.DeepEqual(addresses, fakeClusterAddresses) {
+		t.Errorf("expected recorded addresses %v, got %v", fakeClusterAddresses, addresses)
+	}
+
+	// Make the blocked cluster unreachable; unblocking must use the record.
+	c.Cluster.server = "https://[invalid"
+
+	if err := c.UnblockCluster(context.TODO(), PortFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range c.Targets {
+		for _, node := range target.NodeNames {
+			if routes := executor.Routes(target.Context, node); routes.Len() != 0 {
+				t.Errorf("routes not removed from %s/%s: %v",
+					target.Context, node, sets.List(routes))
+			}
+		}
+
+		record, err := readRecord(context.TODO(), target, "blocked")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record != nil {
+			t.Errorf("record not deleted in %s: %+v", target.Context, record)
+		}
+	}
+}
+
+func TestUnblockClusterCategoriesFromRecord(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.only = []AddressCategory{CategoryRoutes}
+	if err := c.UnblockCluster(context.TODO(), PortFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := sets.New("10.0.0.1", "10.0.0.100", "10.0.0.2", "10.0.0.3")
+	if routes := executor.Routes("hub", "hub-1"); !routes.Equal(expected) {
+		t.Errorf("expected routes %v, got %v", sets.List(expected), sets.List(routes))
+	}
+
+	record, err := readRecord(context.TODO(), c.Targets[0], "blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil {
+		t.Fatal("record deleted while cluster is partly blocked")
+	}
+	if _, ok := record.Addresses[CategoryRoutes]; ok {
+		t.Errorf("routes not removed from record: %+v", record)
+	}
+}
+
+func TestUnblockClusterNotBlocked(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.UnblockCluster(context.TODO(), PortFilter{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterStatus(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	status, err := c.clusterStatus(context.TODO(), PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, status, "hub", true, map[string]BlackholeStatus{
+		"hub-1": StatusUnblocked,
+		"hub-2": StatusUnblocked,
+	})
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = c.clusterStatus(context.TODO(), PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, status, "hub", true, map[string]BlackholeStatus{
+		"hub-1": StatusBlocked,
+		"hub-2": StatusBlocked,
+	})
+	checkStatus(t, status, "cluster2", true, map[string]BlackholeStatus{
+		"cluster2-1": StatusBlocked,
+		"cluster2-2": StatusBlocked,
+		"cluster2-3": StatusBlocked,
+	})
+}
+
+func TestClusterStatusPartlyBlocked(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.AddRoutes("hub", "hub-1", fakeClusterAddresses...)
+	executor.AddRoutes("hub", "hub-2", "10.0.0.1")
+	c := newFakeCommand(executor)
+
+	status, err := c.clusterStatus(context.TODO(), PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, status, "hub", false, map[string]BlackholeStatus{
+		"hub-1": StatusBlocked,
+		"hub-2": StatusPartlyBlocked,
+	})
+	checkStatus(t, status, "cluster2", true, map[string]BlackholeStatus{
+		"cluster2-1": StatusUnblocked,
+		"cluster2-2": StatusUnblocked,
+		"cluster2-3": StatusUnblocked,
+	})
+}
+
+func TestClusterStatusFailure(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.Fail("hub", "hub-2", errors.New("oc debug failed"))
+	executor.Fail("cluster2", "cluster2-3", errors.New("node unreachable"))
+	c := newFakeCommand(executor)
+
+	_, err := c.clusterStatus(context.TODO(), PortFilter{})
+
+	var nodesErr *NodesError
+	if !errors.As(err, &nodesErr) {
+		t.Fatalf("expected *NodesError, got %v", err)
+	}
+
+	// All nodes are inspected, and all failures are reported.
+	checkOutcomes(t, nodesErr.Outcomes, map[string]NodeResult{
+		"hub/hub-1":           ResultDone,
+		"hub/hub-2":           ResultFailed,
+		"cluster2/cluster2-1": ResultDone,
+		"cluster2/cluster2-2": ResultDone,
+		"cluster2/cluster2-3": ResultFailed,
+	})
+
+	expected := `inspecting cluster "blocked" failed on 2 of 5 nodes, 3 done, 0 skipped: ` +
+		"hub/hub-2: oc debug failed; cluster2/cluster2-3: node unreachable"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestUnblockClusterFailure(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("oc debug failed")
+	executor.Fail("hub", "hub-1", failure)
+	executor.Fail("cluster2", "cluster2-2", failure)
+
+	err := c.UnblockCluster(context.TODO(), PortFilter{})
+
+	var nodesErr *NodesError
+	if !errors.As(err, &nodesErr) {
+		t.Fatalf("expected *NodesError, got %v", err)
+	}
+	if !errors.Is(err, failure) {
+		t.Errorf("node error not wrapped: %v", err)
+	}
+
+	checkOutcomes(t, nodesErr.Outcomes, map[string]NodeResult{
+		"hub/hub-1":           ResultFailed,
+		"hub/hub-2":           ResultDone,
+		"cluster2/cluster2-1": ResultDone,
+		"cluster2/cluster2-2": ResultFailed,
+		"cluster2/cluster2-3": ResultDone,
+	})
+
+	// The other nodes were unblocked.
+	if routes := executor.Routes("hub", "hub-2"); routes.Len() != 0 {
+		t.Errorf("hub-2 not unblocked: %v", sets.List(routes))
+	}
+}
+
+func TestRunNodesCancelled(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	outcomes := c.runNodes(ctx, "Unblocking", "unblocked", func(target *targetCluster, nodeName string) error {
+		t.Errorf("node %s/%s started after cancel", target.Context, nodeName)
+		return nil
+	})
+
+	for _, outcome := range outcomes {
+		if outcome.Result != ResultSkipped || !errors.Is(outcome.Err, context.Canceled) {
+			t.Errorf("expected %s/%s skipped, got %s: %v", outcome.Target, outcome.Node, outcome.Result, outcome.Err)
+		}
+	}
+}
+
+func checkOutcomes(t *testing.T, outcomes []NodeOutcome, expected map[string]NodeResult) {
+	t.Helper()
+	results := map[string]NodeResult{}
+	for _, outcome := range outcomes {
+		results[outcome.Target+"/"+outcome.Node] = outcome.Result
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected outcomes %v, got %v", expected, results)
+	}
+}
+
+func TestCollectResultsInconsistent(t *testing.T) {
+	c := newFakeCommand(newFakeExecutor())
+	if err := c.inspectClusters(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same node reporting different state.
+	results := []*nodeResult{
+		{Context: "hub", Node: "hub-1", State: &nodeState{
+			Routes:   sets.New(fakeClusterAddresses...),
+			Degraded: sets.New[string](),
+		}},
+		{Context: "hub", Node: "hub-1", State: &nodeState{
+			Routes:   sets.New[string](),
+			Degraded: sets.New[string](),
+		}},
+	}
+
+	status := c.collectResults(results, PortFilter{})
+	checkStatus(t, status, "hub", false, map[string]BlackholeStatus{
+		"hub-1": StatusUnblocked,
+	})
+}
+
+func TestDegradeCluster(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	options := &NetemOptions{Delay: 200 * time.Millisecond, Loss: "5%"}
+	if err := c.DegradeCluster(context.TODO(), options); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range c.Targets {
+		for _, node := range target.NodeNames {
+			degraded := executor.Degraded(target.Context, node)
+			if !degraded.Equal(sets.New(fakeClusterAddresses...)) {
+				t.Errorf("expected %s/%s degraded %v, got %v",
+					target.Context, node, fakeClusterAddresses, sets.List(degraded))
+			}
+			if netem := executor.Netem(target.Context, node); netem != "delay 200000us loss 5%" {
+				t.Errorf("unexpected %s/%s netem %q", target.Context, node, netem)
+			}
+		}
+	}
+
+	status, err := c.clusterStatus(context.TODO(), PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if network := status["hub"].Network["hub-1"]; network != StatusDegraded {
+		t.Errorf("expected network %q, got %q", StatusDegraded, network)
+	}
+
+	if err := c.RestoreCluster(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = c.clusterStatus(context.TODO(), PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if network := status["hub"].Network["hub-1"]; network != StatusNormal {
+		t.Errorf("expected network %q, got %q", StatusNormal, network)
+	}
+}
+
+func TestDegradeClusterInvalidOptions(t *testing.T) {
+	c := newFakeCommand(newFakeExecutor())
+
+	if err := c.DegradeCluster(context.TODO(), &NetemOptions{}); err == nil {
+		t.Fatal("degrading without impairment did not fail")
+	}
+}
+
+func TestVerifyCluster(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	verification, err := c.VerifyCluster(context.TODO(), StatusUnblocked, PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verification.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 5 nodes, 1 api probe and 2 route probes.
+	if len(verification.Results) != 15 {
+		t.Fatalf("expected 15 results, got %d", len(verification.Results))
+	}
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	verification, err = c.VerifyCluster(context.TODO(), StatusBlocked, PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verification.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verifying the wrong status fails.
+	verification, err = c.VerifyCluster(context.TODO(), StatusUnblocked, PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verification.Err(); err == nil {
+		t.Fatal("verifying blocked cluster as unblocked did not fail")
+	}
+}
+
+func TestVerifyClusterBypass(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodNftReject}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The route traffic goes through a proxy on one node.
+	executor.Bypass("cluster2", "cluster2-2", "10.0.0.200")
+
+	verification, err := c.VerifyCluster(context.TODO(), StatusBlocked, PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := verification.Failed()
+	expected := []ProbeResult{
+		{
+			Target: "cluster2",
+			Node:   "cluster2-2",
+			Probe: Probe{
+				Kind:     ProbeHTTPS,
+				Category: CategoryRoutes,
+				Address:  "10.0.0.200",
+				Port:     443,
+				Host:     "10.0.0.200",
+			},
+			Reachable: true,
+		},
+	}
+	if !reflect.DeepEqual(failed, expected) {
+		t.Fatalf("expected failed %+v, got %+v", expected, failed)
+	}
+	if err := verification.Err(); err == nil {
+		t.Fatal("verification did not fail")
+	}
+}
+
+func TestVerifyClusterPorts(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	filter := PortFilter{Protocol: ProtocolTCP, Ports: []uint16{6443}}
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodNftDrop, Filter: filter}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the API server is probed.
+	verification, err := c.VerifyCluster(context.TODO(), StatusBlocked, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verification.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range verification.Results {
+		if result.Probe.Category != CategoryAPI {
+			t.Errorf("unexpected probe %+v", result.Probe)
+		}
+	}
+
+	// The routes are reachable.
+	verification, err = c.VerifyCluster(context.TODO(), StatusUnblocked, PortFilter{Ports: []uint16{443}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verification.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyClusterNoProbes(t *testing.T) {
+	c := newFakeCommand(newFakeExecutor())
+	c.only = []AddressCategory{CategoryNodes}
+
+	if _, err := c.VerifyCluster(context.TODO(), StatusBlocked, PortFilter{}); err == nil {
+		t.Fatal("verifying without probes did not fail")
+	}
+}
+
+func TestVerifyClusterFailure(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+	executor.Fail("hub", "hub-2", errors.New("node unreachable"))
+
+	if _, err := c.VerifyCluster(context.TODO(), StatusUnblocked, PortFilter{}); err == nil {
+		t.Fatal("verifying with failing node did not fail")
+	}
+}
+
+func checkStatus(
+	t *testing.T,
+	status map[string]*clusterStatus,
+	target string,
+	valid bool,
+	nodes map[string]BlackholeStatus,
+) {
+	t.Helper()
+
+	targetStatus, ok := status[target]
+	if !ok {
+		t.Fatalf("no status for target %q", target)
+	}
+	if targetStatus.Valid != valid {
+		t.Errorf("expected target %q valid %v, got %v", target, valid, targetStatus.Valid)
+	}
+	if !reflect.DeepEqual(targetStatus.Nodes, nodes) {
+		t.Errorf("expected target %q nodes %v, got %v", target, nodes, targetStatus.Nodes)
+	}
+}
+
+// checkAllNodes checks that all target nodes have the expected status for
+// traffic matching filter.
+func checkAllNodes(t *testing.T, c *command, filter PortFilter, expected BlackholeStatus) {
+	t.Helper()
+
+	status, err := c.clusterStatus(context.TODO(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range c.Targets {
+		nodes := map[string]BlackholeStatus{}
+		for _, node := range target.NodeNames {
+			nodes[node] = expected
+		}
+		checkStatus(t, status, target.Context, true, nodes)
+	}
+}
+
+func TestBlockClusterParallel(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+	c.pool = newNodePool(1, 1)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkAllNodes(t, c, PortFilter{}, StatusBlocked)
+
+	if err := c.UnblockCluster(context.TODO(), PortFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkAllNodes(t, c, PortFilter{}, StatusUnblocked)
+}
@@ -0,0 +1,453 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// While a policy is blocking a cluster, we reconcile it periodically to
+// report the current status and fix nodes modified by others.
+const policyResync = 10 * time.Minute
+
+// commandFactory returns a command for blocking the categories of cluster
+// addresses in targets.
+type commandFactory func(cluster string, targets []string, categories []AddressCategory) (*command, error)
+
+// Controller applies the BlackholePolicy resources on the hub.
+type Controller struct {
+	client     dynamic.Interface
+	namespace  string
+	newCommand commandFactory
+	queue      workqueue.RateLimitingInterface
+}
+
+// NewController returns a controller watching policies in namespace, or in
+// all namespaces if namespace is empty. The policy clusters are loaded using
+// options, and the policy specifies the targets and the categories. Progress
+// is not shown since the controller runs unattended.
+func NewController(client dynamic.Interface, namespace string, options *Options) *Controller {
+	return newController(client, namespace, func(cluster string, targets []string, categories []AddressCategory) (*command, error) {
+		policyOptions := *options
+		policyOptions.Targets = targets
+		policyOptions.Categories = categories
+		policyOptions.ProgressOutput = nil
+		return newCommand(cluster, &policyOptions)
+	})
+}
+
+func newController(client dynamic.Interface, namespace string, newCommand commandFactory) *Controller {
+	return &Controller{
+		client:     client,
+		namespace:  namespace,
+		newCommand: newCommand,
+		queue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+}
+
+// Run reconciles the policies until ctx is done.
+func (c *Controller) Run(ctx context.Context) error {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.client, 0, c.namespace, nil)
+	defer factory.Shutdown()
+
+	informer := factory.ForResource(policyGVR).Informer()
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueue(ctx, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// Ignore our status updates.
+			oldPolicy, newPolicy := oldObj.(*unstructured.Unstructured), newObj.(*unstructured.Unstructured)
+			if oldPolicy.GetGeneration() != newPolicy.GetGeneration() ||
+				newPolicy.GetDeletionTimestamp() != nil {
+				c.enqueue(ctx, newObj)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+
+	dbglog(ctx).Printf("Watching policies ...")
+
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return nil
+	}
+
+	go func() {
+		<-ctx.Done()
+		c.queue.ShutDown()
+	}()
+
+	for c.processNext(ctx) {
+	}
+
+	return nil
+}
+
+func (c *Controller) enqueue(ctx context.Context, obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		errlog(ctx).Printf("Cannot enqueue policy: %s", err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// processNext reconciles the next policy. Returns false when the queue was
+// shut down.
+func (c *Controller) processNext(ctx context.Context) bool {
+	item, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	key := item.(string)
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		errlog(ctx).Printf("Invalid policy key %q: %s", key, err)
+		c.queue.Forget(item)
+		return true
+	}
+
+	after, err := c.Reconcile(ctx, namespace, name)
+	if err != nil {
+		errlog(ctx).Printf("Cannot reconcile policy %q: %s", key, err)
+		c.queue.AddRateLimited(item)
+		return true
+	}
+
+	c.queue.Forget(item)
+	if after > 0 {
+		c.queue.AddAfter(item, after)
+	}
+
+	return true
+}
+
+// Reconcile applies the policy, and returns the time to wait before
+// reconciling it again, or zero if the policy does not need to be reconciled
+// until it is modified.
+func (c *Controller) Reconcile(ctx context.Context, namespace string, name string) (time.Duration, error) {
+	policies := c.client.Resource(policyGVR).Namespace(namespace)
+
+	u, err := policies.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	policy, err := policyFromUnstructured(u)
+	if err != nil {
+		return 0, err
+	}
+
+	if policy.DeletionTimestamp != nil {
+		return 0, c.finalize(ctx, policy)
+	}
+
+	if !policy.hasFinalizer() {
+		policy.Finalizers = append(policy.Finalizers, policyFinalizer)
+		policy, err = c.update(ctx, policy)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	after, err := c.apply(ctx, policy)
+	if err != nil {
+		policy.Status.Phase = PhaseFailed
+		meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+			Type:               conditionBlocked,
+			Status:             metav1.ConditionUnknown,
+			Reason:             "Error",
+			Message:            err.Error(),
+			ObservedGeneration: policy.Generation,
+		})
+	}
+
+	policy.Status.ObservedGeneration = policy.Generation
+
+	if _, updateErr := c.updateStatus(ctx, policy); updateErr != nil && err == nil {
+		err = updateErr
+	}
+
+	return after, err
+}
+
+// apply blocks or unblocks the cluster according to the policy schedule, and
+// updates the policy status.
+func (c *Controller) apply(ctx context.Context, policy *BlackholePolicy) (time.Duration, error) {
+	spec := &policy.Spec
+
+	method, err := spec.method()
+	if err != nil {
+		return 0, err
+	}
+
+	filter, err := spec.filter()
+	if err != nil {
+		return 0, err
+	}
+
+	categories, err := spec.categories()
+	if err != nil {
+		return 0, err
+	}
+
+	// Unblock targets removed from the policy.
+	if err := c.unblockRemoved(ctx, policy, method); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	start, expires := policy.schedule()
+
+	if !expires.IsZero() {
+		policy.Status.Expires = &metav1.Time{Time: expires}
+	} else {
+		policy.Status.Expires = nil
+	}
+
+	switch {
+	case now.Before(start):
+		if err := c.unblockApplied(ctx, policy); err != nil {
+			return 0, err
+		}
+		policy.Status.Phase = PhasePending
+		setBlockedCondition(policy, metav1.ConditionFalse, "Pending",
+			fmt.Sprintf("Waiting until %s", start.UTC().Format(time.RFC3339)))
+		return start.Sub(now), nil
+
+	case !expires.IsZero() && !now.Before(expires):
+		if err := c.unblockApplied(ctx, policy); err != nil {
+			return 0, err
+		}
+		policy.Status.Phase = PhaseExpired
+		policy.Status.Targets = nil
+		setBlockedCondition(policy, metav1.ConditionFalse, "Expired",
+			fmt.Sprintf("Unblocked at %s", expires.UTC().Format(time.RFC3339)))
+		return 0, nil
+	}
+
+	command, err := c.newCommand(spec.Cluster, spec.Targets, categories)
+	if err != nil {
+		return 0, err
+	}
+
+	options := &blockOptions{Method: method, Filter: filter}
+	if !expires.IsZero() {
+		// The target nodes unblock the cluster even if the controller is
+		// not running.
+		options.Expire = expires.Sub(now) + ExpiryGrace
+	}
+
+	dbglog(ctx).Printf("Applying policy %s/%s ...", policy.Namespace, policy.Name)
+
+	// Record the policy before blocking, so we can unblock if blocking fails
+	// in the middle.
+	policy.Status.Applied = &AppliedPolicy{
+		Cluster:    spec.Cluster,
+		Targets:    spec.Targets,
+		Method:     method,
+		Categories: spec.Only,
+		Protocol:   spec.Protocol,
+		Ports:      spec.Ports,
+	}
+	if _, err := c.updateStatus(ctx, policy); err != nil {
+		return 0, err
+	}
+
+	if err := command.BlockCluster(ctx, options); err != nil {
+		return 0, err
+	}
+
+	status, err := command.clusterStatus(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	policy.Status.Phase = PhaseBlocked
+	policy.Status.Targets = newShowOutput(spec.Cluster, spec.Targets, status, false).Status.Targets
+
+	if blocked, message := policyBlocked(spec.Targets, status); blocked {
+		setBlockedCondition(policy, metav1.ConditionTrue, "Blocked", message)
+	} else {
+		setBlockedCondition(policy, metav1.ConditionFalse, "PartlyBlocked", message)
+	}
+
+	after := policyResync
+	if !expires.IsZero() && expires.Sub(now) < after {
+		after = expires.Sub(now)
+	}
+
+	return after, nil
+}
+
+// policyBlocked returns true if the cluster is blocked in all target nodes,
+// and a message describing the status.
+func policyBlocked(targets []string, status map[string]*clusterStatus) (bool, string) {
+	total, blocked := 0, 0
+	for _, target := range targets {
+		for _, nodeStatus := range status[target].Nodes {
+			total++
+			if nodeStatus == StatusBlocked {
+				blocked++
+			}
+		}
+	}
+	return blocked == total, fmt.Sprintf("Blocked in %d of %d target nodes", blocked, total)
+}
+
+func setBlockedCondition(policy *BlackholePolicy, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+		Type:               conditionBlocked,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: policy.Generation,
+	})
+}
+
+// unblockRemoved unblocks the applied cluster in targets removed from the
+// policy. If the blocked cluster, the method, the categories, or the filter
+// was modified, the applied cluster is unblocked in all applied targets.
+func (c *Controller) unblockRemoved(ctx context.Context, policy *BlackholePolicy, method BlockMethod) error {
+	applied := policy.Status.Applied
+	if applied == nil {
+		return nil
+	}
+
+	spec := &policy.Spec
+
+	// Policies applied before the method was recorded used the default
+	// method.
+	appliedMethod := applied.Method
+	if appliedMethod == "" {
+		appliedMethod = MethodRoute
+	}
+
+	removed := sets.New(applied.Targets...)
+	if applied.Cluster == spec.Cluster &&
+		appliedMethod == method &&
+		sets.New(applied.Categories...).Equal(sets.New(spec.Only...)) &&
+		applied.Protocol == spec.Protocol &&
+		sets.New(applied.Ports...).Equal(sets.New(spec.Ports...)) {
+		removed.Delete(spec.Targets...)
+	}
+
+	if removed.Len() == 0 {
+		return nil
+	}
+
+	if err := c.unblock(ctx, applied, sets.List(removed)); err != nil {
+		return err
+	}
+
+	if removed.Len() == len(applied.Targets) {
+		policy.Status.Applied = nil
+	} else {
+		applied.Targets = sets.List(sets.New(applied.Targets...).Difference(removed))
+	}
+
+	return nil
+}
+
+// unblockApplied unblocks the applied cluster in all applied targets.
+func (c *Controller) unblockApplied(ctx context.Context, policy *BlackholePolicy) error {
+	applied := policy.Status.Applied
+	if applied == nil {
+		return nil
+	}
+
+	if err := c.unblock(ctx, applied, applied.Targets); err != nil {
+		return err
+	}
+
+	policy.Status.Applied = nil
+	return nil
+}
+
+func (c *Controller) unblock(ctx context.Context, applied *AppliedPolicy, targets []string) error {
+	dbglog(ctx).Printf("Unblocking cluster %q in targets %v ...", applied.Cluster, targets)
+
+	filter, err := ParsePortFilter(applied.Protocol, applied.Ports)
+	if err != nil {
+		return err
+	}
+
+	command, err := c.newCommand(applied.Cluster, targets, applied.Categories)
+	if err != nil {
+		return err
+	}
+
+	return command.UnblockCluster(ctx, filter)
+}
+
+// finalize unblocks the cluster, and removes our finalizer, so the policy can
+// be deleted.
+func (c *Controller) finalize(ctx context.Context, policy *BlackholePolicy) error {
+	if !policy.hasFinalizer() {
+		return nil
+	}
+
+	dbglog(ctx).Printf("Finalizing policy %s/%s ...", policy.Namespace, policy.Name)
+
+	if err := c.unblockApplied(ctx, policy); err != nil {
+		return err
+	}
+
+	policy.removeFinalizer()
+	_, err := c.update(ctx, policy)
+	return err
+}
+
+func (c *Controller) update(ctx context.Context, policy *BlackholePolicy) (*BlackholePolicy, error) {
+	u, err := policyToUnstructured(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err = c.client.Resource(policyGVR).Namespace(policy.Namespace).Update(ctx, u, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return policyFromUnstructured(u)
+}
+
+// updateStatus updates the policy status, and the policy resource version.
+func (c *Controller) updateStatus(ctx context.Context, policy *BlackholePolicy) (*BlackholePolicy, error) {
+	u, err := policyToUnstructured(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err = c.client.Resource(policyGVR).Namespace(policy.Namespace).UpdateStatus(ctx, u, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	policy.ResourceVersion = u.GetResourceVersion()
+	return policy, nil
+}
@@ -0,0 +1,443 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// newFakeController returns a controller using a fake hub, blocking the fake
+// command cluster in the fake command targets.
+func newFakeController(t *testing.T, executor *fakeExecutor, policies ...*BlackholePolicy) *Controller {
+	var objects []runtime.Object
+	for _, policy := range policies {
+		u, err := policyToUnstructured(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, u)
+	}
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{policyGVR: policyKind + "List"},
+		objects...,
+	)
+
+	base := newFakeCommand(executor)
+	targets := map[string]*targetCluster{}
+	for _, target := range base.Targets {
+		targets[target.Context] = target
+	}
+
+	return newController(client, "", func(cluster string, targetNames []string, categories []AddressCategory) (*command, error) {
+		if cluster != base.Cluster.Context {
+			return nil, fmt.Errorf("unknown cluster %q", cluster)
+		}
+		c := &command{
+			Cluster:  base.Cluster,
+			only:     categories,
+			executor: executor,
+			pool:     newNodePool(0, 0),
+			progress: newProgressReporter("testing", 0, io.Discard),
+		}
+		for _, name := range targetNames {
+			target, ok := targets[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown target %q", name)
+			}
+			c.Targets = append(c.Targets, target)
+		}
+		return c, nil
+	})
+}
+
+func newFakePolicy(spec PolicySpec) *BlackholePolicy {
+	return &BlackholePolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: policyGroup + "/" + policyVersion,
+			Kind:       policyKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "block-blocked",
+			Namespace:         "default",
+			Generation:        1,
+			CreationTimestamp: metav1.Now(),
+		},
+		Spec: spec,
+	}
+}
+
+func getPolicy(t *testing.T, c *Controller) *BlackholePolicy {
+	u, err := c.client.Resource(policyGVR).Namespace("default").Get(
+		context.TODO(), "block-blocked", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := policyFromUnstructured(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func checkNodesRoutes(t *testing.T, executor *fakeExecutor, nodes map[string][]string, expected sets.Set[string]) {
+	for target, nodeNames := range nodes {
+		for _, node := range nodeNames {
+			if routes := executor.Routes(target, node); !routes.Equal(expected) {
+				t.Errorf("expected %s/%s routes %v, got %v",
+					target, node, sets.List(expected), sets.List(routes))
+			}
+		}
+	}
+}
+
+var fakeTargetNodes = map[string][]string{
+	"hub":      {"hub-1", "hub-2"},
+	"cluster2": {"cluster2-1", "cluster2-2", "cluster2-3"},
+}
+
+func TestControllerBlock(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeController(t, executor, newFakePolicy(PolicySpec{
+		Cluster: "blocked",
+		Targets: []string{"hub", "cluster2"},
+	}))
+
+	after, err := c.Reconcile(context.TODO(), "default", "block-blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after != policyResync {
+		t.Errorf("expected requeue after %s, got %s", policyResync, after)
+	}
+
+	checkNodesRoutes(t, executor, fakeTargetNodes, sets.New(fakeClusterAddresses...))
+
+	policy := getPolicy(t, c)
+
+	if !policy.hasFinalizer() {
+		t.Errorf("finalizer not added: %v", policy.Finalizers)
+	}
+	if policy.Status.Phase != PhaseBlocked {
+		t.Errorf("expected phase %q, got %q", PhaseBlocked, policy.Status.Phase)
+	}
+	if policy.Status.ObservedGeneration != 1 {
+		t.Errorf("expected observed generation 1, got %d", policy.Status.ObservedGeneration)
+	}
+	if !meta.IsStatusConditionTrue(policy.Status.Conditions, conditionBlocked) {
+		t.Errorf("expected condition %q true, got %+v", conditionBlocked, policy.Status.Conditions)
+	}
+	if len(policy.Status.Targets) != 2 || len(policy.Status.Targets[1].Nodes) != 3 {
+		t.Fatalf("unexpected targets status %+v", policy.Status.Targets)
+	}
+	for _, target := range policy.Status.Targets {
+		for _, node := range target.Nodes {
+			if node.Status != StatusBlocked {
+				t.Errorf("expected %s/%s blocked, got %s", target.Name, node.Name, node.Status)
+			}
+		}
+	}
+}
+
+func TestControllerRemoveTarget(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeController(t, executor, newFakePolicy(PolicySpec{
+		Cluster: "blocked",
+		Targets: []string{"hub", "cluster2"},
+	}))
+
+	if _, err := c.Reconcile(context.TODO(), "default", "block-blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := getPolicy(t, c)
+	policy.Spec.Targets = []string{"hub"}
+	policy.Generation++
+	if _, err := c.update(context.TODO(), policy); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Reconcile(context.TODO(), "default", "block-blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkNodesRoutes(t, executor, map[string][]string{"hub": fakeTargetNodes["hub"]},
+		sets.New(fakeClusterAddresses...))
+	checkNodesRoutes(t, executor, map[string][]string{"cluster2": fakeTargetNodes["cluster2"]},
+		sets.New[string]())
+
+	policy = getPolicy(t, c)
+	if len(policy.Status.Applied.Targets) != 1 || policy.Status.Applied.Targets[0] != "hub" {
+		t.Errorf("unexpected applied targets %v", policy.Status.Applied.Targets)
+	}
+}
+
+func TestControllerChangeCategories(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeController(t, executor, newFakePolicy(PolicySpec{
+		Cluster: "blocked",
+		Targets: []string{"hub", "cluster2"},
+	}))
+
+	if _, err := c.Reconcile(context.TODO(), "default", "block-blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := getPolicy(t, c)
+	policy.Spec.Only = []AddressCategory{CategoryAPI}
+	policy.Generation++
+	if _, err := c.update(context.TODO(), policy); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Reconcile(context.TODO(), "default", "block-blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The nodes addresses blocked by the previous spec were unblocked.
+	checkNodesRoutes(t, executor, fakeTargetNodes, sets.New("10.0.0.100"))
+
+	policy = getPolicy(t, c)
+	if categories := policy.Status.Applied.Categories; len(categories) != 1 || categories[0] != CategoryAPI {
+		t.Errorf("unexpected applied categories %v", categories)
+	}
+}
+
+func TestControllerChangeMethod(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeController(t, executor, newFakePolicy(PolicySpec{
+		Cluster: "blocked",
+		Targets: []string{"hub", "cluster2"},
+	}))
+
+	if _, err := c.Reconcile(context.TODO(), "default", "block-blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := getPolicy(t, c)
+	policy.Spec.Method = MethodNftDrop
+	policy.Generation++
+	if _, err := c.update(context.TODO(), policy); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Reconcile(context.TODO(), "default", "block-blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The routes added by the previous spec were deleted.
+	checkNodesRoutes(t, executor, fakeTargetNodes, sets.New[string]())
+
+	for target, nodes := range fakeTargetNodes {
+		for _, node := range nodes {
+			if dropped := executor.NftSet(target, node, "drop_v4"); dropped.Len() == 0 {
+				t.Errorf("%s/%s not blocked using nft", target, node)
+			}
+		}
+	}
+
+	policy = getPolicy(t, c)
+	if method := policy.Status.Applied.Method; method != MethodNftDrop {
+		t.Errorf("unexpected applied method %q", method)
+	}
+}
+
+func TestControllerPending(t *testing.T) {
+	executor := newFakeExecutor()
+	start := metav1.NewTime(time.Now().Add(time.Hour))
+	c := newFakeController(t, executor, newFakePolicy(PolicySpec{
+		Cluster: "blocked",
+		Targets: []string{"hub"},
+		Start:   &start,
+	}))
+
+	after, err := c.Reconcile(context.TODO(), "default", "block-blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after <= 59*time.Minute || after > time.Hour {
+		t.Errorf("expected requeue after about 1h, got %s", after)
+	}
+
+	checkNodesRoutes(t, executor, map[string][]string{"hub": fakeTargetNodes["hub"]}, sets.New[string]())
+
+	policy := getPolicy(t, c)
+	if policy.Status.Phase != PhasePending {
+		t.Errorf("expected phase %q, got %q", PhasePending, policy.Status.Phase)
+	}
+}
+
+func TestControllerExpire(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeController(t, executor, newFakePolicy(PolicySpec{
+		Cluster:  "blocked",
+		Targets:  []string{"hub"},
+		Method:   MethodNftDrop,
+		Duration: &metav1.Duration{Duration: time.Hour},
+	}))
+
+	after, err := c.Reconcile(context.TODO(), "default", "block-blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after > time.Hour {
+		t.Errorf("expected requeue before expiry, got %s", after)
+	}
+
+	// The target nodes unblock the cluster even if the controller is down.
+	if timers := executor.Timers("hub", "hub-1"); len(timers) != 1 {
+		t.Errorf("expected expiry timer, got %v", timers)
+	}
+
+	// Move the start time to make the policy expire.
+	policy := getPolicy(t, c)
+	start := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	policy.Spec.Start = &start
+	policy.Generation++
+	if _, err := c.update(context.TODO(), policy); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err = c.Reconcile(context.TODO(), "default", "block-blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after != 0 {
+		t.Errorf("expected no requeue, got %s", after)
+	}
+
+	if set := executor.NftSet("hub", "hub-1", "drop_v4"); set.Len() != 0 {
+		t.Errorf("cluster not unblocked: %v", sets.List(set))
+	}
+
+	policy = getPolicy(t, c)
+	if policy.Status.Phase != PhaseExpired {
+		t.Errorf("expected phase %q, got %q", PhaseExpired, policy.Status.Phase)
+	}
+	if policy.Status.Applied != nil {
+		t.Errorf("unexpected applied policy %+v", policy.Status.Applied)
+	}
+	if !meta.IsStatusConditionFalse(policy.Status.Conditions, conditionBlocked) {
+		t.Errorf("expected condition %q false, got %+v", conditionBlocked, policy.Status.Conditions)
+	}
+}
+
+func TestControllerFinalize(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeController(t, executor, newFakePolicy(PolicySpec{
+		Cluster: "blocked",
+		Targets: []string{"hub", "cluster2"},
+	}))
+
+	if _, err := c.Reconcile(context.TODO(), "default", "block-blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake client does not handle finalizers, so we simulate deletion.
+	policy := getPolicy(t, c)
+	now := metav1.Now()
+	policy.DeletionTimestamp = &now
+	if _, err := c.update(context.TODO(), policy); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Reconcile(context.TODO(), "default", "block-blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkNodesRoutes(t, executor, fakeTargetNodes, sets.New[string]())
+
+	policy = getPolicy(t, c)
+	if policy.hasFinalizer() {
+		t.Errorf("finalizer not removed: %v", policy.Finalizers)
+	}
+}
+
+func TestControllerFailure(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.Fail("hub", "hub-2", fmt.Errorf("node unreachable"))
+	c := newFakeController(t, executor, newFakePolicy(PolicySpec{
+		Cluster: "blocked",
+		Targets: []string{"hub"},
+	}))
+
+	if _, err := c.Reconcile(context.TODO(), "default", "block-blocked"); err == nil {
+		t.Fatal("reconcile did not fail")
+	}
+
+	policy := getPolicy(t, c)
+	if policy.Status.Phase != PhaseFailed {
+		t.Errorf("expected phase %q, got %q", PhaseFailed, policy.Status.Phase)
+	}
+	condition := meta.FindStatusCondition(policy.Status.Conditions, conditionBlocked)
+	if condition == nil || condition.Reason != "Error" || !strings.HasSuffix(condition.Message, ": node unreachable") {
+		t.Errorf("unexpected condition %+v", condition)
+	}
+
+	// The applied policy is kept so we can unblock nodes blocked before the
+	// failure.
+	if policy.Status.Applied == nil {
+		t.Error("applied policy not recorded")
+	}
+}
+
+func TestControllerMissingPolicy(t *testing.T) {
+	c := newFakeController(t, newFakeExecutor())
+
+	after, err := c.Reconcile(context.TODO(), "default", "missing")
+	if err != nil || after != 0 {
+		t.Errorf("expected (0, nil), got (%s, %v)", after, err)
+	}
+}
+
+func TestPolicySpecInvalid(t *testing.T) {
+	for _, spec := range []PolicySpec{
+		{Cluster: "blocked", Targets: []string{"hub"}, Method: "bad"},
+		{Cluster: "blocked", Targets: []string{"hub"}, Only: []AddressCategory{"bad"}},
+		{Cluster: "blocked", Targets: []string{"hub"}, Protocol: "icmp"},
+		{Cluster: "blocked", Targets: []string{"hub"}, Ports: []uint{443}},
+	} {
+		executor := newFakeExecutor()
+		c := newFakeController(t, executor, newFakePolicy(spec))
+		if _, err := c.Reconcile(context.TODO(), "default", "block-blocked"); err == nil {
+			t.Errorf("reconciling %+v did not fail", spec)
+		}
+	}
+}
+
+func TestInstallPolicyCRD(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{crdGVR: "CustomResourceDefinitionList"},
+	)
+
+	// Installing twice updates the definition.
+	for i := 0; i < 2; i++ {
+		if err := InstallPolicyCRD(context.TODO(), client); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	crd, err := client.Resource(crdGVR).Get(context.TODO(), policyResource+"."+policyGroup, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind"); kind != policyKind {
+		t.Errorf("expected kind %q, got %q", policyKind, kind)
+	}
+}
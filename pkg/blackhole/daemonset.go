@@ -0,0 +1,281 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	agentNamespace      = "oc-blackhole"
+	agentName           = "oc-blackhole-agent"
+	agentServiceAccount = "oc-blackhole-agent"
+
+	// How long we wait until the agent is running on all nodes.
+	agentTimeout = 5 * time.Minute
+)
+
+var agentLabels = map[string]string{"app": agentName}
+
+// agentExecutor runs scripts in the agent pods installed on the target
+// clusters by `oc blackhole agent install`. Since the agent pods are already
+// running, this is much faster than starting a new debug pod for every script.
+type agentExecutor struct {
+	targets map[string]*targetCluster
+}
+
+func newAgentExecutor(targets []*targetCluster) *agentExecutor {
+	e := &agentExecutor{targets: map[string]*targetCluster{}}
+	for _, target := range targets {
+		e.targets[target.Context] = target
+	}
+	return e
+}
+
+func (e *agentExecutor) Run(ctx context.Context, context string, nodeName string, script string) ([]byte, error) {
+	target, ok := e.targets[context]
+	if !ok {
+		return nil, fmt.Errorf("unknown target cluster %q", context)
+	}
+
+	pod, err := findAgentPod(ctx, target, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	command := []string{"chroot", "/host", "sh", "-c", script}
+	dbglog(ctx).Printf("Running command on node %s: %s", nodeName, command)
+
+	return execInPod(ctx, target.k8sClient, target.restConfig, agentNamespace,
+		pod, debugContainer, command)
+}
+
+func findAgentPod(ctx context.Context, target *targetCluster, nodeName string) (string, error) {
+	pods, err := target.k8sClient.CoreV1().Pods(agentNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: agentLabels}),
+		FieldSelector: "spec.nodeName=" + nodeName,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == apiv1.PodRunning && pod.DeletionTimestamp == nil {
+			return pod.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("no running agent on cluster %q node %q (run `oc blackhole agent install`)",
+		target.Context, nodeName)
+}
+
+// InstallAgent installs the agent on all the options targets.
+func InstallAgent(ctx context.Context, options *Options) error {
+	ctx = options.context(ctx)
+
+	targets, err := loadTargets(options)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if err := installAgent(ctx, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UninstallAgent removes leftover changes, the block records, and the agent
+// from all the options targets.
+func UninstallAgent(ctx context.Context, options *Options) error {
+	ctx = options.context(ctx)
+
+	targets, err := loadTargets(options)
+	if err != nil {
+		return err
+	}
+
+	pool := options.pool()
+
+	for _, target := range targets {
+		if err := uninstallAgent(ctx, pool, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// installAgent deploys the agent daemonset on the target cluster and waits
+// until the agent is running on all nodes. Installing an installed agent
+// updates the daemonset.
+func installAgent(ctx context.Context, target *targetCluster) error {
+	dbglog(ctx).Printf("Installing agent on cluster %q ...", target.Context)
+
+	client := target.k8sClient
+
+	_, err := client.CoreV1().Namespaces().Create(ctx, agentNamespaceObject(), metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	_, err = client.CoreV1().ServiceAccounts(agentNamespace).Create(ctx, agentServiceAccountObject(), metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	_, err = client.RbacV1().RoleBindings(agentNamespace).Create(ctx, agentRoleBindingObject(), metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	daemonsets := client.AppsV1().DaemonSets(agentNamespace)
+	_, err = daemonsets.Create(ctx, agentDaemonSetObject(), metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = daemonsets.Update(ctx, agentDaemonSetObject(), metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	return waitForAgent(ctx, target)
+}
+
+func waitForAgent(ctx context.Context, target *targetCluster) error {
+	daemonsets := target.k8sClient.AppsV1().DaemonSets(agentNamespace)
+
+	return wait.PollUntilContextTimeout(ctx, 2*time.Second, agentTimeout, true,
+		func(ctx context.Context) (bool, error) {
+			ds, err := daemonsets.Get(ctx, agentName, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+
+			dbglog(ctx).Printf("Agent on cluster %q: desired %d, updated %d, ready %d",
+				target.Context,
+				ds.Status.DesiredNumberScheduled,
+				ds.Status.UpdatedNumberScheduled,
+				ds.Status.NumberReady)
+
+			return ds.Status.ObservedGeneration >= ds.Generation &&
+				ds.Status.DesiredNumberScheduled > 0 &&
+				ds.Status.UpdatedNumberScheduled == ds.Status.DesiredNumberScheduled &&
+				ds.Status.NumberReady == ds.Status.DesiredNumberScheduled, nil
+		})
+}
+
+// uninstallAgent removes leftover changes from the target cluster nodes using
+// the agent, deletes the block records, and deletes the agent namespace.
+func uninstallAgent(ctx context.Context, pool *nodePool, target *targetCluster) error {
+	dbglog(ctx).Printf("Uninstalling agent from cluster %q ...", target.Context)
+
+	client := target.k8sClient
+
+	_, err := client.CoreV1().Namespaces().Get(ctx, agentNamespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		dbglog(ctx).Printf("Agent not installed on cluster %q", target.Context)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := target.Inspect(ctx); err != nil {
+		return err
+	}
+
+	executor := newAgentExecutor([]*targetCluster{target})
+
+	operation := fmt.Sprintf("uninstalling agent from cluster %q", target.Context)
+	err = modifyTargets(ctx, pool, operation, []*targetCluster{target}, func(target *targetCluster, nodeName string) error {
+		return clearNode(ctx, executor, target.Context, nodeName)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The nodes are not blocked now, so the records are stale.
+	if err := deleteAllRecords(ctx, target); err != nil {
+		return err
+	}
+
+	return client.CoreV1().Namespaces().Delete(ctx, agentNamespace, metav1.DeleteOptions{})
+}
+
+func agentNamespaceObject() *apiv1.Namespace {
+	return &apiv1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: agentNamespace,
+			Labels: map[string]string{
+				"pod-security.kubernetes.io/enforce":             "privileged",
+				"pod-security.kubernetes.io/audit":               "privileged",
+				"pod-security.kubernetes.io/warn":                "privileged",
+				"security.openshift.io/scc.podSecurityLabelSync": "false",
+			},
+		},
+	}
+}
+
+func agentServiceAccountObject() *apiv1.ServiceAccount {
+	return &apiv1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      agentServiceAccount,
+			Namespace: agentNamespace,
+		},
+	}
+}
+
+// agentRoleBindingObject allows the agent service account to use the
+// privileged SCC on OpenShift.
+func agentRoleBindingObject() *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      agentName,
+			Namespace: agentNamespace,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     "system:openshift:scc:privileged",
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      agentServiceAccount,
+				Namespace: agentNamespace,
+			},
+		},
+	}
+}
+
+func agentDaemonSetObject() *appsv1.DaemonSet {
+	spec := privilegedPodSpec([]string{"sh", "-c", "while true; do sleep 3600; done"})
+	spec.ServiceAccountName = agentServiceAccount
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      agentName,
+			Namespace: agentNamespace,
+			Labels:    agentLabels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: agentLabels},
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: agentLabels},
+				Spec:       spec,
+			},
+		},
+	}
+}
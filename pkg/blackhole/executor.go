@@ -0,0 +1,227 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// A tiny busybox image (1.6m) - we need only working `chroot`.
+const debugImage = "quay.io/nirsof/busybox:stable-musl"
+
+// nodeExecutor runs a shell script on a node of the cluster specified by the
+// kubeconfig context. The script runs in the host root filesystem, and the
+// executor returns the script output.
+type nodeExecutor interface {
+	Run(ctx context.Context, context string, nodeName string, script string) ([]byte, error)
+}
+
+const (
+	ExecutorOcDebug = "oc-debug"
+	ExecutorPod     = "pod"
+	ExecutorAgent   = "agent"
+)
+
+// The delay before the first retry, doubled after every retry.
+var retryBackoff = time.Second
+
+// newExecutor returns the options executor for running scripts on the target
+// clusters nodes. Scripts taking more than the options node timeout are
+// cancelled, and transient failures are retried.
+func newExecutor(options *Options, targets []*targetCluster) (nodeExecutor, error) {
+	var executor nodeExecutor
+	switch name := options.Executor; name {
+	case ExecutorOcDebug, "":
+		executor = &ocDebugExecutor{}
+	case ExecutorPod:
+		executor = newPodExecutor(targets)
+	case ExecutorAgent:
+		executor = newAgentExecutor(targets)
+	default:
+		return nil, fmt.Errorf("unknown executor %q", name)
+	}
+	return &retryExecutor{
+		Executor: executor,
+		Timeout:  options.NodeTimeout,
+		Retries:  options.Retries,
+		Backoff:  retryBackoff,
+	}, nil
+}
+
+// retryExecutor runs scripts using another executor, limiting the time of
+// every attempt, and retrying attempts that timed out or failed with a
+// transient error. Adding blocks replaces existing blocks, and deleting
+// blocks ignores missing blocks, so running a script again after a partial
+// failure is safe.
+type retryExecutor struct {
+	Executor nodeExecutor
+
+	// Timeout for every attempt. If zero, attempts are not limited.
+	Timeout time.Duration
+
+	// Number of retries after the first attempt.
+	Retries int
+
+	// Delay before the first retry, doubled after every retry.
+	Backoff time.Duration
+}
+
+func (e *retryExecutor) Run(ctx context.Context, context string, nodeName string, script string) ([]byte, error) {
+	delay := e.Backoff
+	for attempt := 0; ; attempt++ {
+		out, err := e.attempt(ctx, context, nodeName, script)
+		if err == nil {
+			return out, nil
+		}
+		if attempt == e.Retries || ctx.Err() != nil || !isTransient(err) {
+			return nil, err
+		}
+		dbglog(ctx).Printf("Retrying on cluster %q node %q in %s: %s", context, nodeName, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+		delay *= 2
+	}
+}
+
+// attempt runs the script once, cancelling it if it takes more than the
+// timeout. A timed out attempt is considered transient.
+func (e *retryExecutor) attempt(ctx context.Context, targetContext string, nodeName string, script string) ([]byte, error) {
+	if e.Timeout == 0 {
+		return e.Executor.Run(ctx, targetContext, nodeName, script)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+
+	out, err := e.Executor.Run(attemptCtx, targetContext, nodeName, script)
+	if err != nil && ctx.Err() == nil && attemptCtx.Err() != nil {
+		return nil, &transientError{
+			err: fmt.Errorf("timed out after %s on cluster %q node %q: %w", e.Timeout, targetContext, nodeName, err),
+		}
+	}
+	return out, err
+}
+
+// transientError is a failure that may succeed if retried.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// isTransient returns true if err may succeed if retried.
+func isTransient(err error) bool {
+	var transient *transientError
+	if errors.As(err, &transient) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsInternalError(err)
+}
+
+// Messages of `oc debug` failures that may succeed if retried, for example
+// when the API server is temporarily unavailable or the debug pod could not
+// be started.
+var transientMessages = []string{
+	"unable to create the debug pod",
+	"Unable to connect to the server",
+	"timed out waiting for the condition",
+	"the server is currently unable to handle the request",
+	"connection refused",
+	"connection reset by peer",
+	"i/o timeout",
+	"TLS handshake timeout",
+}
+
+// transientOutput returns true if `oc debug` output shows a transient
+// failure.
+func transientOutput(out []byte) bool {
+	s := string(out)
+	for _, msg := range transientMessages {
+		if strings.Contains(s, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// When a script is cancelled, `oc debug` is interrupted so it can delete the
+// debug pod, and killed if it does not exit within ocDebugWaitDelay.
+const ocDebugWaitDelay = 30 * time.Second
+
+// ocDebugExecutor runs scripts using `oc debug node/...`. It requires the `oc`
+// command and starts a new debug pod for every script.
+type ocDebugExecutor struct{}
+
+func (e *ocDebugExecutor) Run(ctx context.Context, context string, nodeName string, script string) ([]byte, error) {
+	cmd := exec.CommandContext(
+		ctx,
+		"oc",
+		"debug",
+		"node/"+nodeName,
+		// Silence unhelpful informational messages
+		"--quiet",
+		"--image="+debugImage,
+		"--context="+context,
+		"--",
+		"chroot",
+		"/host",
+		"sh",
+		"-c",
+		script,
+	)
+
+	// Killing `oc debug` leaves the debug pod behind.
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = ocDebugWaitDelay
+
+	dbglog(ctx).Printf("Running command on node %s: %s", nodeName, cmd.Args)
+
+	out, err := cmd.Output()
+	if err != nil {
+		// Due to the way `oc debug` is implemented, stdrrr of the underlying
+		// commnad is redirected to stdout.
+		// https://bugzilla.redhat.com/1771549
+		runErr := fmt.Errorf("oc debug failed: %s: %s", err, out)
+
+		// Errors from `oc` itself, like failing to start the debug pod, are
+		// written to stderr.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && transientOutput(exitErr.Stderr) {
+			return nil, &transientError{err: fmt.Errorf("%w: %s", runErr, exitErr.Stderr)}
+		}
+		return nil, runErr
+	}
+
+	return out, nil
+}
@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// flakyExecutor fails the first attempts with errs, and then succeeds. If an
+// error is nil, the attempt hangs until the context is done.
+type flakyExecutor struct {
+	errs     []error
+	attempts int
+}
+
+func (e *flakyExecutor) Run(ctx context.Context, context string, nodeName string, script string) ([]byte, error) {
+	attempt := e.attempts
+	e.attempts++
+	if attempt < len(e.errs) {
+		if e.errs[attempt] == nil {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return nil, e.errs[attempt]
+	}
+	return []byte("ok"), nil
+}
+
+func newRetryExecutor(executor nodeExecutor, retries int) *retryExecutor {
+	return &retryExecutor{
+		Executor: executor,
+		Timeout:  50 * time.Millisecond,
+		Retries:  retries,
+		Backoff:  time.Millisecond,
+	}
+}
+
+func TestRetryExecutorTransient(t *testing.T) {
+	transient := &transientError{err: errors.New("unable to create the debug pod")}
+	unavailable := apierrors.NewServiceUnavailable("try again")
+	flaky := &flakyExecutor{errs: []error{transient, unavailable}}
+
+	out, err := newRetryExecutor(flaky, 2).Run(context.Background(), "target", "node-1", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "ok" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if flaky.attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", flaky.attempts)
+	}
+}
+
+func TestRetryExecutorTimeout(t *testing.T) {
+	flaky := &flakyExecutor{errs: []error{nil}}
+
+	_, err := newRetryExecutor(flaky, 1).Run(context.Background(), "target", "node-1", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flaky.attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", flaky.attempts)
+	}
+}
+
+func TestRetryExecutorRetriesExhausted(t *testing.T) {
+	flaky := &flakyExecutor{errs: []error{nil, nil, nil}}
+
+	_, err := newRetryExecutor(flaky, 2).Run(context.Background(), "target", "node-1", "true")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if flaky.attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", flaky.attempts)
+	}
+}
+
+func TestRetryExecutorPermanent(t *testing.T) {
+	notFound := apierrors.NewNotFound(schema.GroupResource{Resource: "nodes"}, "node-1")
+	flaky := &flakyExecutor{errs: []error{notFound}}
+
+	_, err := newRetryExecutor(flaky, 2).Run(context.Background(), "target", "node-1", "true")
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if flaky.attempts != 1 {
+		t.Fatalf("expected 1 attempt, got %d", flaky.attempts)
+	}
+}
+
+func TestRetryExecutorCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	flaky := &flakyExecutor{errs: []error{nil}}
+	executor := newRetryExecutor(flaky, 2)
+	executor.Timeout = 0
+
+	_, err := executor.Run(ctx, "target", "node-1", "true")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+	if flaky.attempts != 1 {
+		t.Fatalf("expected 1 attempt, got %d", flaky.attempts)
+	}
+}
+
+// partialExecutor runs only the first line of the first script, and fails
+// with a transient error, like a connection dropped in the middle of a
+// script.
+type partialExecutor struct {
+	nodeExecutor
+	attempts int
+}
+
+func (e *partialExecutor) Run(ctx context.Context, context string, nodeName string, script string) ([]byte, error) {
+	e.attempts++
+	if e.attempts > 1 {
+		return e.nodeExecutor.Run(ctx, context, nodeName, script)
+	}
+	first, _, _ := strings.Cut(script, "\n")
+	if _, err := e.nodeExecutor.Run(ctx, context, nodeName, first); err != nil {
+		return nil, err
+	}
+	return nil, &transientError{err: errors.New("connection reset by peer")}
+}
+
+func TestRetryExecutorPartialUnblock(t *testing.T) {
+	fake := newFakeExecutor()
+	addresses := []string{"10.0.0.1", "10.0.0.2"}
+
+	for _, method := range []BlockMethod{MethodRoute, MethodNftDrop} {
+		err := blockNode(context.Background(), fake, "hub", "hub-1", method, addresses, PortFilter{}, "unit", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state, err := inspectNode(context.Background(), fake, "hub", "hub-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := unblockScript(state, addresses, PortFilter{}, "unit", false)
+
+	// The first attempt deletes only the first route, so the retry must not
+	// fail on the deleted route.
+	partial := &partialExecutor{nodeExecutor: fake}
+	executor := newRetryExecutor(partial, 1)
+	executor.Timeout = 0
+
+	if _, err := executor.Run(context.Background(), "hub", "hub-1", script); err != nil {
+		t.Fatal(err)
+	}
+	if partial.attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", partial.attempts)
+	}
+
+	if routes := fake.Routes("hub", "hub-1"); routes.Len() != 0 {
+		t.Errorf("unexpected routes %v", sets.List(routes))
+	}
+	if elements := fake.NftSet("hub", "hub-1", "drop_v4"); elements.Len() != 0 {
+		t.Errorf("unexpected elements %v", sets.List(elements))
+	}
+}
+
+// fakeOc is an `oc` command recording if it was interrupted, like `oc debug`
+// deleting the debug pod when interrupted.
+const fakeOc = `#!/bin/sh
+sleep 10 &
+trap 'touch "$FAKE_OC_DIR/interrupted"; kill $!; exit 1' INT
+touch "$FAKE_OC_DIR/started"
+wait
+`
+
+func TestOcDebugExecutorCancelled(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "oc"), []byte(fakeOc), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_OC_DIR", dir)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan error)
+	go func() {
+		_, err := (&ocDebugExecutor{}).Run(ctx, "hub", "hub-1", "true")
+		done <- err
+	}()
+
+	// Cancel when the trap was installed.
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "started")); err == nil {
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("oc exited before cancelling: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+
+	if err := <-done; err == nil {
+		t.Fatal("cancelled command did not fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "interrupted")); err != nil {
+		t.Errorf("oc was not interrupted: %s", err)
+	}
+}
+
+func TestTransientOutput(t *testing.T) {
+	cases := []struct {
+		out       string
+		transient bool
+	}{
+		{"error: unable to create the debug pod \"node-1-debug\"", true},
+		{"Unable to connect to the server: dial tcp: i/o timeout", true},
+		{"error: nodes \"node-1\" not found", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%q", c.out), func(t *testing.T) {
+			if res := transientOutput([]byte(c.out)); res != c.transient {
+				t.Fatalf("expected %v, got %v", c.transient, res)
+			}
+		})
+	}
+}
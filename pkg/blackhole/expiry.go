@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// When a block expires, the target nodes unblock the cluster using a systemd
+// timer, so the cluster is unblocked even if oc-blackhole was killed.
+const expiryUnitPrefix = "oc-blackhole-expire-"
+
+// When waiting for a timed block, the target nodes unblock the cluster after
+// the duration and this grace time, in case we were killed.
+const ExpiryGrace = time.Minute
+
+var invalidUnitChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// expiryUnit returns the name of the systemd unit unblocking the blocked
+// cluster on the target nodes.
+func expiryUnit(blockedContext string) string {
+	return expiryUnitPrefix + invalidUnitChars.ReplaceAllString(blockedContext, "-")
+}
+
+// scheduleExpiryScript returns a script starting a systemd timer running
+// commands after the specified duration. The commands must not contain single
+// quotes.
+func scheduleExpiryScript(unit string, commands []string, after time.Duration) string {
+	script := strings.Join(append(commands, "true"), "; ")
+	return fmt.Sprintf(
+		"systemd-run --quiet --unit=%s --on-active=%ds --timer-property=AccuracySec=1s sh -c '%s'\n",
+		unit, int64(after.Seconds()), script)
+}
+
+// cancelExpiryScript returns a script stopping the systemd timer. Stopping
+// a timer that does not exist is not an error. The unit name may be a glob
+// pattern.
+func cancelExpiryScript(unit string) string {
+	return fmt.Sprintf("systemctl stop '%s.timer' 2>/dev/null || true\n", unit)
+}
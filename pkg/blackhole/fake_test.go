@@ -0,0 +1,547 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	routev1 "github.com/openshift/api/route/v1"
+	routefake "github.com/openshift/client-go/route/clientset/versioned/fake"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeExecutor simulates the target clusters nodes, running the scripts
+// generated by the commands.
+type fakeExecutor struct {
+	mutex sync.Mutex
+	// Nodes per "context/node".
+	nodes map[string]*fakeNodeState
+}
+
+// fakeNodeState keeps the node state modified by the scripts.
+type fakeNodeState struct {
+	// Blackhole routes, the address with the route options, like
+	// "10.0.0.1 proto 143 metric 1".
+	routes sets.Set[string]
+	// nftables sets elements, nil if the table does not exist.
+	nft map[string]sets.Set[string]
+	// Degraded addresses.
+	degraded sets.Set[string]
+	// Netem arguments.
+	netem string
+	// Expiry timers, mapping unit name to the timer --on-active value.
+	timers map[string]string
+	// Addresses reachable even when blocked, like traffic going through a
+	// proxy.
+	bypass sets.Set[string]
+	// Error returned when running a script.
+	err error
+	// Commands starting with this prefix fail.
+	failCommand string
+	// Number of scripts run on the node.
+	runs int
+}
+
+func newFakeExecutor() *fakeExecutor {
+	return &fakeExecutor{nodes: map[string]*fakeNodeState{}}
+}
+
+func (e *fakeExecutor) Run(ctx context.Context, context string, nodeName string, script string) ([]byte, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	node := e.node(context, nodeName)
+	node.runs++
+	if node.err != nil {
+		return nil, node.err
+	}
+
+	return node.run(script)
+}
+
+// Routes returns the addresses of our blackhole routes on a node.
+func (e *fakeExecutor) Routes(context string, nodeName string) sets.Set[string] {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	res := sets.New[string]()
+	for route := range e.node(context, nodeName).routes {
+		if address, ok := strings.CutSuffix(route, routeSpec); ok {
+			res.Insert(address)
+		}
+	}
+	return res
+}
+
+// ForeignRoutes returns the addresses of foreign blackhole routes on a node.
+func (e *fakeExecutor) ForeignRoutes(context string, nodeName string) sets.Set[string] {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	res := sets.New[string]()
+	for route := range e.node(context, nodeName).routes {
+		if !strings.HasSuffix(route, routeSpec) {
+			res.Insert(route)
+		}
+	}
+	return res
+}
+
+// NftSet returns the elements of a nftables set on a node.
+func (e *fakeExecutor) NftSet(context string, nodeName string, set string) sets.Set[string] {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.node(context, nodeName).nft[set].Clone()
+}
+
+// Degraded returns the degraded addresses on a node.
+func (e *fakeExecutor) Degraded(context string, nodeName string) sets.Set[string] {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.node(context, nodeName).degraded.Clone()
+}
+
+// Netem returns the netem arguments on a node.
+func (e *fakeExecutor) Netem(context string, nodeName string) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.node(context, nodeName).netem
+}
+
+// Runs returns the number of scripts run on a node.
+func (e *fakeExecutor) Runs(context string, nodeName string) int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.node(context, nodeName).runs
+}
+
+// Timers returns the expiry timers on a node.
+func (e *fakeExecutor) Timers(context string, nodeName string) map[string]string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	res := map[string]string{}
+	for unit, active := range e.node(context, nodeName).timers {
+		res[unit] = active
+	}
+	return res
+}
+
+// AddRoutes adds our blackhole routes to a node.
+func (e *fakeExecutor) AddRoutes(context string, nodeName string, addresses ...string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, address := range addresses {
+		e.node(context, nodeName).routes.Insert(address + routeSpec)
+	}
+}
+
+// AddForeignRoutes adds blackhole routes installed by others to a node.
+func (e *fakeExecutor) AddForeignRoutes(context string, nodeName string, addresses ...string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.node(context, nodeName).routes.Insert(addresses...)
+}
+
+// Bypass makes addresses reachable from a node even when blocked.
+func (e *fakeExecutor) Bypass(context string, nodeName string, addresses ...string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.node(context, nodeName).bypass.Insert(addresses...)
+}
+
+// FailCommand makes commands starting with prefix fail on a node, after the
+// previous commands in the script were run.
+func (e *fakeExecutor) FailCommand(context string, nodeName string, prefix string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.node(context, nodeName).failCommand = prefix
+}
+
+// Fail makes scripts running on a node fail.
+func (e *fakeExecutor) Fail(context string, nodeName string, err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.node(context, nodeName).err = err
+}
+
+func (e *fakeExecutor) node(context string, nodeName string) *fakeNodeState {
+	key := context + "/" + nodeName
+	node, ok := e.nodes[key]
+	if !ok {
+		node = &fakeNodeState{
+			routes:   sets.New[string](),
+			degraded: sets.New[string](),
+			timers:   map[string]string{},
+			bypass:   sets.New[string](),
+		}
+		e.nodes[key] = node
+	}
+	return node
+}
+
+func (n *fakeNodeState) run(script string) ([]byte, error) {
+	var out bytes.Buffer
+
+	// Functions definitions used by the scripts.
+	script = strings.Replace(script, netemFunctions, "", 1)
+	script = strings.Replace(script, probeFunctions, "", 1)
+
+	lines := strings.Split(script, "\n")
+
+	// Exit status of the last subshell, stopping on the first error.
+	subshell := false
+	status := 0
+
+	for i := 0; i < len(lines); i++ {
+		command := strings.Join(strings.Fields(lines[i]), " ")
+
+		ignoreErrors := strings.HasSuffix(command, " 2>/dev/null || true")
+		command = strings.TrimSuffix(command, " 2>/dev/null || true")
+
+		var err error
+
+		switch {
+		case command == "":
+			continue
+		case n.failCommand != "" && strings.HasPrefix(command, n.failCommand):
+			err = fmt.Errorf("%s: Operation not permitted", command)
+		case command == "(":
+			subshell, status = true, 0
+		case command == ") 2>&1":
+			subshell = false
+		case command == "set -e", command == "status=$?":
+			continue
+		case command == "echo $status":
+			fmt.Fprintln(&out, status)
+		case command == "nft -f - <<'EOF'":
+			// Setting up the table; skip the here document.
+			for i++; i < len(lines) && lines[i] != "EOF"; i++ {
+			}
+			if n.nft == nil {
+				n.nft = map[string]sets.Set[string]{}
+			}
+		case strings.HasPrefix(command, "nft "):
+			err = n.runNft(command, &out)
+		case strings.HasPrefix(command, "ip "):
+			err = n.runIP(command, &out)
+		case strings.HasPrefix(command, "echo "):
+			fmt.Fprintln(&out, strings.Trim(strings.TrimPrefix(command, "echo "), "'"))
+		case strings.HasPrefix(command, "oc_blackhole_degrade "):
+			args := strings.SplitN(command, "'", 3)
+			n.netem = args[1]
+			n.degraded.Insert(strings.Fields(args[2])...)
+		case strings.HasPrefix(command, "oc_blackhole_restore "):
+			n.degraded.Delete(strings.Fields(command)[1:]...)
+		case command == "oc_blackhole_restore_all":
+			n.degraded.Clear()
+		case command == "oc_blackhole_netem_show":
+			for _, address := range sets.List(n.degraded) {
+				fmt.Fprintln(&out, address)
+			}
+		case strings.HasPrefix(command, "oc_blackhole_probe_tcp "),
+			strings.HasPrefix(command, "oc_blackhole_probe_https "):
+			n.probe(command, &out)
+		case strings.HasPrefix(command, "systemctl stop "):
+			fields := strings.Fields(command)
+			pattern := strings.TrimSuffix(strings.Trim(fields[2], "'"), ".timer")
+			for unit := range n.timers {
+				if ok, _ := path.Match(pattern, unit); ok {
+					delete(n.timers, unit)
+				}
+			}
+		case strings.HasPrefix(command, "systemd-run "):
+			var unit, active string
+			for _, field := range strings.Fields(command) {
+				if strings.HasPrefix(field, "--unit=") {
+					unit = strings.TrimPrefix(field, "--unit=")
+				} else if strings.HasPrefix(field, "--on-active=") {
+					active = strings.TrimPrefix(field, "--on-active=")
+				}
+			}
+			if _, ok := n.timers[unit]; ok {
+				err = fmt.Errorf("Unit %s.timer already exists", unit)
+			} else {
+				n.timers[unit] = active
+			}
+		default:
+			err = fmt.Errorf("unsupported command %q", lines[i])
+		}
+
+		if err != nil && !ignoreErrors {
+			if !subshell {
+				return nil, err
+			}
+			// Skip the rest of the subshell.
+			fmt.Fprintln(&out, err)
+			status = 1
+			for i++; i < len(lines) && lines[i] != ") 2>&1"; i++ {
+			}
+			subshell = false
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// probe writes the probe result like the probe functions. The address is
+// unreachable if it has a blackhole route, or if the probe traffic matches a
+// nft element.
+func (n *fakeNodeState) probe(command string, out io.Writer) {
+	fields := strings.Fields(command)
+	kind := strings.TrimPrefix(fields[0], "oc_blackhole_probe_")
+	address, port := fields[1], fields[2]
+	host := "-"
+	if len(fields) > 3 {
+		host = fields[3]
+	}
+
+	blocked := false
+	for route := range n.routes {
+		if strings.Fields(route)[0] == address {
+			blocked = true
+		}
+	}
+	element := address + elementSeparator + ProtocolTCP + elementSeparator + port
+	for _, elements := range n.nft {
+		if elementBlocked(element, elements) {
+			blocked = true
+		}
+	}
+
+	result := "reachable"
+	if blocked && !n.bypass.Has(address) {
+		result = "unreachable"
+	}
+	fmt.Fprintf(out, "%s %s %s %s %s\n", kind, address, port, host, result)
+}
+
+func (n *fakeNodeState) runIP(command string, out io.Writer) error {
+	switch {
+	case strings.HasPrefix(command, "ip route replace blackhole "):
+		n.routes.Insert(strings.TrimPrefix(command, "ip route replace blackhole "))
+	case strings.HasPrefix(command, "ip route del blackhole "):
+		route := strings.TrimPrefix(command, "ip route del blackhole ")
+		// Unmarked routes are kept without options.
+		route = strings.TrimSuffix(route, legacyRouteSpec)
+		if !n.routes.Has(route) {
+			return fmt.Errorf("RTNETLINK answers: No such process")
+		}
+		n.routes.Delete(route)
+	case command == "ip -4 route show type blackhole":
+		for _, route := range sets.List(n.routes) {
+			if !strings.Contains(route, ":") {
+				fmt.Fprintf(out, "blackhole %s \n", route)
+			}
+		}
+	case command == "ip -6 route show type blackhole":
+		for _, route := range sets.List(n.routes) {
+			if strings.Contains(route, ":") {
+				address, options, _ := strings.Cut(route, " ")
+				if options == "" {
+					options = "metric 1024"
+				}
+				fmt.Fprintf(out, "blackhole %s dev lo %s pref medium \n", address, options)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported command %q", command)
+	}
+
+	return nil
+}
+
+func (n *fakeNodeState) runNft(command string, out io.Writer) error {
+	switch {
+	case command == "nft -j list table inet oc_blackhole":
+		if n.nft == nil {
+			return fmt.Errorf("Error: No such file or directory")
+		}
+		return n.listNft(out)
+	case command == "nft delete table inet oc_blackhole":
+		if n.nft == nil {
+			return fmt.Errorf("Error: No such file or directory")
+		}
+		n.nft = nil
+	case strings.HasPrefix(command, "nft add element inet oc_blackhole "),
+		strings.HasPrefix(command, "nft delete element inet oc_blackhole "):
+		if n.nft == nil {
+			return fmt.Errorf("Error: No such file or directory")
+		}
+		fields := strings.SplitN(command, " ", 6)
+		set := fields[5][:strings.Index(fields[5], " ")]
+		start := strings.Index(command, "{")
+		end := strings.LastIndex(command, "}")
+		var elements []string
+		for _, element := range strings.Split(command[start+1:end], ",") {
+			elements = append(elements, strings.TrimSpace(element))
+		}
+		if n.nft[set] == nil {
+			n.nft[set] = sets.New[string]()
+		}
+		if fields[1] == "add" {
+			n.nft[set].Insert(elements...)
+		} else {
+			if !n.nft[set].HasAll(elements...) {
+				return fmt.Errorf("Error: Could not process rule: No such file or directory")
+			}
+			n.nft[set].Delete(elements...)
+		}
+	default:
+		return fmt.Errorf("unsupported command %q", command)
+	}
+
+	return nil
+}
+
+// listNft writes the table sets like `nft -j list table`.
+func (n *fakeNodeState) listNft(out io.Writer) error {
+	var items []interface{}
+	items = append(items, map[string]interface{}{
+		"table": map[string]interface{}{"family": "inet", "name": "oc_blackhole"},
+	})
+	for _, name := range sets.List(sets.KeySet(n.nft)) {
+		set := map[string]interface{}{"family": "inet", "table": "oc_blackhole", "name": name}
+		var elements []interface{}
+		for _, element := range sets.List(n.nft[name]) {
+			elements = append(elements, fakeNftElement(element))
+		}
+		if len(elements) > 0 {
+			set["elem"] = elements
+		}
+		items = append(items, map[string]interface{}{"set": set})
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"nftables": items})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%s\n", data)
+	return nil
+}
+
+// fakeNftElement returns the JSON value of a set element, using concat
+// objects for concatenations like nft.
+func fakeNftElement(element string) interface{} {
+	parts := strings.Split(element, elementSeparator)
+	if len(parts) == 1 {
+		return element
+	}
+	var concat []interface{}
+	for _, part := range parts {
+		if port, err := strconv.Atoi(part); err == nil {
+			concat = append(concat, port)
+		} else {
+			concat = append(concat, part)
+		}
+	}
+	return map[string]interface{}{"concat": concat}
+}
+
+type fakeRoute struct {
+	Name  string
+	Hosts []string
+}
+
+// newFakeBlockedCluster returns a blocked cluster using fake clients. Use
+// addresses instead of host names to avoid DNS lookups.
+func newFakeBlockedCluster(
+	context string,
+	server string,
+	nodeAddresses map[string]string,
+	routes []fakeRoute,
+) *blockedCluster {
+	var nodes []runtime.Object
+	for name, address := range nodeAddresses {
+		nodes = append(nodes, fakeNode(name, address))
+	}
+
+	var routeObjects []runtime.Object
+	for _, route := range routes {
+		routeObjects = append(routeObjects, fakeRouteObject(route))
+	}
+
+	return newBlockedCluster(
+		context,
+		server,
+		k8sfake.NewSimpleClientset(nodes...),
+		routefake.NewSimpleClientset(routeObjects...).RouteV1(),
+	)
+}
+
+// newFakeTargetCluster returns a target cluster using a fake client.
+func newFakeTargetCluster(context string, nodeNames ...string) *targetCluster {
+	var nodes []runtime.Object
+	for _, name := range nodeNames {
+		nodes = append(nodes, fakeNode(name, ""))
+	}
+
+	return newTargetCluster(context, nil, k8sfake.NewSimpleClientset(nodes...))
+}
+
+func fakeNode(name string, externalIP string) *apiv1.Node {
+	node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	node.Status.Addresses = append(node.Status.Addresses, apiv1.NodeAddress{
+		Type:    apiv1.NodeHostName,
+		Address: name,
+	})
+	if externalIP != "" {
+		node.Status.Addresses = append(node.Status.Addresses, apiv1.NodeAddress{
+			Type:    apiv1.NodeExternalIP,
+			Address: externalIP,
+		})
+	}
+	return node
+}
+
+func fakeRouteObject(route fakeRoute) *routev1.Route {
+	obj := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{Name: route.Name, Namespace: "default"},
+	}
+	for _, host := range route.Hosts {
+		obj.Status.Ingress = append(obj.Status.Ingress, routev1.RouteIngress{Host: host})
+	}
+	return obj
+}
+
+// newFakeCommand returns a command blocking cluster "blocked" in targets "hub"
+// and "cluster2", using a fake executor.
+func newFakeCommand(executor nodeExecutor) *command {
+	cluster := newFakeBlockedCluster(
+		"blocked",
+		"https://10.0.0.100:6443",
+		map[string]string{
+			"blocked-1": "10.0.0.1",
+			"blocked-2": "10.0.0.2",
+			"blocked-3": "10.0.0.3",
+		},
+		[]fakeRoute{
+			{Name: "s3", Hosts: []string{"10.0.0.200"}},
+			{Name: "console", Hosts: []string{"10.0.0.200", "fd00::200"}},
+		},
+	)
+
+	targets := []*targetCluster{
+		newFakeTargetCluster("hub", "hub-1", "hub-2"),
+		newFakeTargetCluster("cluster2", "cluster2-1", "cluster2-2", "cluster2-3"),
+	}
+
+	return &command{
+		Cluster:  cluster,
+		Targets:  targets,
+		executor: executor,
+		pool:     newNodePool(0, 0),
+		progress: newProgressReporter("testing", 0, io.Discard),
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Our blackhole routes are marked with a dedicated protocol number, so we
+// never modify blackhole routes installed by the platform or other tools. The
+// dedicated metric keeps our route separate from a foreign blackhole route
+// for the same address, which would be replaced by `ip route replace`
+// otherwise.
+const (
+	routeProto  = "143"
+	routeMetric = "1"
+)
+
+// routeSpec is appended to the route address when adding or deleting our
+// routes.
+const routeSpec = " proto " + routeProto + " metric " + routeMetric
+
+// Older versions added unmarked routes using the default protocol. Deleting
+// them requires the protocol, so our marked routes are never deleted instead.
+const legacyRouteSpec = " proto boot"
+
+// blackholeRoutesScript lists the blackhole routes on a node.
+//
+// `ip route replace` and `ip route del` handle both ipv4 and ipv6 routes,
+// but `ip route show` return only ipv4 routes.
+const blackholeRoutesScript = `
+ip -4 route show type blackhole
+ip -6 route show type blackhole
+`
+
+// addRoutesScript returns a script adding blackhole routes for addresses.
+func addRoutesScript(addresses []string) string {
+	var sb strings.Builder
+	for _, address := range addresses {
+		// `replace` is idempotent, no need to check for existing blackholes.
+		sb.WriteString("ip route replace blackhole " + address + routeSpec + "\n")
+	}
+	return sb.String()
+}
+
+// deleteRoutesScript returns a script deleting blackhole routes for
+// addresses. `ip route del` fails if the route does not exist, so errors are
+// ignored, and the script can run again after a partial failure.
+func deleteRoutesScript(addresses []string) string {
+	var sb strings.Builder
+	for _, address := range addresses {
+		sb.WriteString("ip route del blackhole " + address + routeSpec + " 2>/dev/null || true\n")
+	}
+	return sb.String()
+}
+
+// deleteLegacyRoutesScript returns a script deleting unmarked blackhole
+// routes added by older versions for addresses. Errors are ignored like in
+// deleteRoutesScript.
+func deleteLegacyRoutesScript(addresses []string) string {
+	var sb strings.Builder
+	for _, address := range addresses {
+		sb.WriteString("ip route del blackhole " + address + legacyRouteSpec + " 2>/dev/null || true\n")
+	}
+	return sb.String()
+}
+
+// expireRoutesCommands returns commands deleting blackhole routes for
+// addresses when a block expires. The routes may have been deleted already.
+func expireRoutesCommands(addresses []string) []string {
+	var res []string
+	for _, address := range addresses {
+		res = append(res, "ip route del blackhole "+address+routeSpec+" 2>/dev/null")
+	}
+	return res
+}
+
+// parseBlackholeRoute returns the address and the protocol of a blackhole
+// route. The protocol is empty for routes using the default protocol, since
+// `ip route show` does not show it.
+func parseBlackholeRoute(line string) (string, string, error) {
+	// We want the second field and the proto value:
+	// - ipv4: "blackhole 172.217.22.14 proto 143 metric 1 "
+	// - ipv6: "blackhole 2a00:1450:4028:809::200e dev lo proto 143 metric 1 pref medium "
+	// - unmarked: "blackhole 172.217.22.14 "
+	fields := strings.Fields(line)
+
+	// Should never happen, so fail loudly.
+	if len(fields) < 2 || fields[0] != "blackhole" {
+		return "", "", fmt.Errorf("invalid route %q", line)
+	}
+
+	proto := ""
+	for i := 2; i < len(fields)-1; i++ {
+		if fields[i] == "proto" {
+			proto = fields[i+1]
+		}
+	}
+
+	return fields[1], proto, nil
+}
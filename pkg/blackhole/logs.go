@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"io"
+	"log"
+)
+
+type loggerKey struct{}
+type errorLoggerKey struct{}
+
+var discardLogger = log.New(io.Discard, "", 0)
+
+// WithLogger returns a context logging debug messages to logger.
+func WithLogger(ctx context.Context, logger *log.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// WithErrorLogger returns a context logging errors that cannot be returned,
+// such as failures to clean up, to logger.
+func WithErrorLogger(ctx context.Context, logger *log.Logger) context.Context {
+	return context.WithValue(ctx, errorLoggerKey{}, logger)
+}
+
+// dbglog returns the debug logger of ctx. Debug messages are discarded by
+// default.
+func dbglog(ctx context.Context) *log.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*log.Logger); ok && logger != nil {
+		return logger
+	}
+	return discardLogger
+}
+
+// errlog returns the error logger of ctx. Errors are logged using the standard
+// logger by default.
+func errlog(ctx context.Context) *log.Logger {
+	if logger, ok := ctx.Value(errorLoggerKey{}).(*log.Logger); ok && logger != nil {
+		return logger
+	}
+	return log.Default()
+}
@@ -0,0 +1,235 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Degrading the network adds a prio qdisc on the devices used to reach the
+// degraded addresses. Every degraded cluster gets its own band with a netem
+// qdisc, and a flower filter for every cluster address sends the traffic to
+// the cluster band, so clusters can be degraded with different parameters.
+// Other traffic is handled like the default pfifo_fast qdisc. Restoring the
+// network deletes the cluster filters and netem qdisc, and the prio qdisc when
+// no filter is left.
+//
+// Bands 1-3 are used for other traffic, so we can degrade up to 12 clusters
+// using bands 4-f. The netem qdisc of band N has the handle 1bcN:.
+//
+// The functions are defined in every script using them.
+const netemFunctions = `
+oc_blackhole_dev() {
+    ip route get "$1" 2>/dev/null | sed -n 's/.* dev \([^ ]*\).*/\1/p' | head -n 1
+}
+
+oc_blackhole_devs() {
+    tc qdisc show | awk '$2 == "prio" && $3 == "1beb:" {print $5}'
+}
+
+oc_blackhole_filters() {
+    tc filter show dev "$1" parent 1beb: 2>/dev/null | awk '
+        {for (i = 1; i < NF; i++) if ($i == "classid") {split($(i + 1), c, ":"); band = c[2]}}
+        $1 == "dst_ip" {print $2, band}'
+}
+
+oc_blackhole_netem_bands() {
+    tc qdisc show dev "$1" | awk '$2 == "netem" {
+        for (i = 1; i < NF; i++) if ($i == "parent") {split($(i + 1), c, ":"); if (c[1] == "1beb") print c[2]}}'
+}
+
+oc_blackhole_set_filters() {
+    dev=$1
+    shift
+    tc filter del dev "$dev" parent 1beb: 2>/dev/null || true
+    while [ $# -gt 1 ]; do
+        case "$1" in
+            *:*) proto="ipv6 prio 2" ;;
+            *) proto="ip prio 1" ;;
+        esac
+        tc filter add dev "$dev" parent 1beb: protocol $proto flower dst_ip "$1" classid "1beb:$2" || return
+        shift 2
+    done
+}
+
+oc_blackhole_band() {
+    filters=$(oc_blackhole_filters "$1")
+    shift
+    for addr in "$@"; do
+        band=$(echo "$filters" | awk -v addr="$addr" '$1 == addr {print $2}')
+        if [ -n "$band" ]; then
+            echo "$band"
+            return
+        fi
+    done
+    for band in 4 5 6 7 8 9 a b c d e f; do
+        if ! echo "$filters" | awk '{print $2}' | grep -qx "$band"; then
+            echo "$band"
+            return
+        fi
+    done
+    echo "no free band for degrading more clusters" >&2
+    return 1
+}
+
+oc_blackhole_degrade() {
+    netem=$1
+    shift
+    for dev in $(for addr in "$@"; do oc_blackhole_dev "$addr"; done | sort -u); do
+        addrs=$(for addr in "$@"; do
+            if [ "$(oc_blackhole_dev "$addr")" = "$dev" ]; then echo "$addr"; fi
+        done)
+        if ! oc_blackhole_devs | grep -qx "$dev"; then
+            tc qdisc add dev "$dev" root handle 1beb: prio bands 16 priomap 1 2 2 2 1 2 0 0 1 1 1 1 1 1 1 1 || return
+        fi
+        band=$(oc_blackhole_band "$dev" $addrs) || return
+        tc qdisc replace dev "$dev" parent "1beb:$band" handle "1bc$band:" netem $netem || return
+        filters=$(
+            oc_blackhole_filters "$dev" | while read addr old; do
+                case " $(echo $addrs) " in
+                    *" $addr "*) ;;
+                    *) echo "$addr $old" ;;
+                esac
+            done
+            for addr in $addrs; do echo "$addr $band"; done
+        )
+        oc_blackhole_set_filters "$dev" $filters || return
+    done
+}
+
+oc_blackhole_restore() {
+    for dev in $(oc_blackhole_devs); do
+        keep=$(oc_blackhole_filters "$dev" | while read addr band; do
+            case " $* " in
+                *" $addr "*) ;;
+                *) echo "$addr $band" ;;
+            esac
+        done)
+        if [ -z "$keep" ]; then
+            tc qdisc del dev "$dev" root || return
+            continue
+        fi
+        oc_blackhole_set_filters "$dev" $keep || return
+        for band in $(oc_blackhole_netem_bands "$dev"); do
+            if ! echo "$keep" | awk '{print $2}' | grep -qx "$band"; then
+                tc qdisc del dev "$dev" parent "1beb:$band" || return
+            fi
+        done
+    done
+}
+
+oc_blackhole_restore_all() {
+    for dev in $(oc_blackhole_devs); do
+        tc qdisc del dev "$dev" root || return
+    done
+}
+
+oc_blackhole_netem_show() {
+    for dev in $(oc_blackhole_devs); do
+        oc_blackhole_filters "$dev" | awk '{print $1}'
+    done
+}
+`
+
+var (
+	netemLossPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?%$`)
+	netemRatePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[a-zA-Z]*$`)
+)
+
+// NetemOptions describe how the network is degraded.
+type NetemOptions struct {
+	// Delay added to every packet.
+	Delay time.Duration
+	// Random variation of the delay.
+	Jitter time.Duration
+	// Percentage of dropped packets (e.g. "5%").
+	Loss string
+	// Maximum bandwidth (e.g. "10mbit").
+	Rate string
+}
+
+// Args returns the netem qdisc arguments.
+func (o *NetemOptions) Args() (string, error) {
+	var args []string
+
+	if o.Jitter != 0 && o.Delay == 0 {
+		return "", fmt.Errorf("jitter requires delay")
+	}
+
+	if o.Delay < 0 || o.Jitter < 0 {
+		return "", fmt.Errorf("invalid delay %s or jitter %s", o.Delay, o.Jitter)
+	}
+
+	if o.Delay != 0 {
+		args = append(args, "delay", fmt.Sprintf("%dus", o.Delay.Microseconds()))
+		if o.Jitter != 0 {
+			args = append(args, fmt.Sprintf("%dus", o.Jitter.Microseconds()))
+		}
+	}
+
+	if o.Loss != "" {
+		loss := o.Loss
+		if !strings.HasSuffix(loss, "%") {
+			loss += "%"
+		}
+		if !netemLossPattern.MatchString(loss) {
+			return "", fmt.Errorf("invalid loss %q", o.Loss)
+		}
+		args = append(args, "loss", loss)
+	}
+
+	if o.Rate != "" {
+		if !netemRatePattern.MatchString(o.Rate) {
+			return "", fmt.Errorf("invalid rate %q", o.Rate)
+		}
+		args = append(args, "rate", o.Rate)
+	}
+
+	if len(args) == 0 {
+		return "", fmt.Errorf("no network impairment specified")
+	}
+
+	return strings.Join(args, " "), nil
+}
+
+// degradeNetwork degrades the network from the node to addresses. Degrading
+// degraded addresses replaces the netem parameters of the addresses band,
+// without changing other degraded addresses.
+func degradeNetwork(
+	ctx context.Context,
+	executor nodeExecutor,
+	context string,
+	nodeName string,
+	addresses []string,
+	netem string,
+) error {
+	dbglog(ctx).Printf("degrading addresses in node %s", nodeName)
+
+	script := netemFunctions + fmt.Sprintf("oc_blackhole_degrade '%s' %s\n",
+		netem, strings.Join(addresses, " "))
+
+	_, err := executor.Run(ctx, context, nodeName, script)
+	return err
+}
+
+// restoreNetwork removes the network degradation from the node to addresses.
+func restoreNetwork(
+	ctx context.Context,
+	executor nodeExecutor,
+	context string,
+	nodeName string,
+	addresses []string,
+) error {
+	dbglog(ctx).Printf("restoring addresses in node %s", nodeName)
+
+	script := netemFunctions + fmt.Sprintf("oc_blackhole_restore %s\n",
+		strings.Join(addresses, " "))
+
+	_, err := executor.Run(ctx, context, nodeName, script)
+	return err
+}
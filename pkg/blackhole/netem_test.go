@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestNetemArgs(t *testing.T) {
+	cases := []struct {
+		options  NetemOptions
+		expected string
+	}{
+		{NetemOptions{Delay: 200 * time.Millisecond}, "delay 200000us"},
+		{NetemOptions{Delay: 200 * time.Millisecond, Jitter: 50 * time.Millisecond}, "delay 200000us 50000us"},
+		{NetemOptions{Loss: "5%"}, "loss 5%"},
+		{NetemOptions{Loss: "0.5"}, "loss 0.5%"},
+		{NetemOptions{Rate: "10mbit"}, "rate 10mbit"},
+		{
+			NetemOptions{Delay: time.Second, Loss: "1%", Rate: "1gbit"},
+			"delay 1000000us loss 1% rate 1gbit",
+		},
+	}
+
+	for _, c := range cases {
+		args, err := c.options.Args()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if args != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, args)
+		}
+	}
+}
+
+func TestNetemArgsInvalid(t *testing.T) {
+	cases := []NetemOptions{
+		{},
+		{Jitter: time.Second},
+		{Delay: -time.Second},
+		{Loss: "five"},
+		{Loss: "5%'; reboot; '"},
+		{Rate: "10 mbit"},
+	}
+
+	for _, options := range cases {
+		if args, err := options.Args(); err == nil {
+			t.Errorf("options %+v did not fail: %q", options, args)
+		}
+	}
+}
+
+// fakeTC simulates the tc and ip commands used by the netem functions,
+// keeping the qdiscs and filters in files in $STATE. Like the kernel, filters
+// with different protocols cannot use the same prio. All addresses are
+// reached using eth0.
+const fakeTC = `
+ip() {
+    echo "$3 dev eth0 src 10.0.0.9"
+}
+
+tc() {
+    obj=$1 cmd=$2
+    shift 2
+    touch "$STATE/qdisc" "$STATE/filter"
+    case "$obj $cmd" in
+    "qdisc show")
+        cat "$STATE/qdisc"
+        ;;
+    "qdisc add")
+        if grep -q " dev $2 root " "$STATE/qdisc"; then
+            echo "Error: Exclusivity flag on, cannot modify." >&2
+            return 2
+        fi
+        echo "qdisc prio $5 dev $2 root refcnt 2 bands 16" >> "$STATE/qdisc"
+        ;;
+    "qdisc replace")
+        dev=$2 parent=$4 handle=$6
+        shift 7
+        grep -v " dev $dev parent $parent " "$STATE/qdisc" > "$STATE/tmp"
+        echo "qdisc netem $handle dev $dev parent $parent limit 1000 $*" >> "$STATE/tmp"
+        mv "$STATE/tmp" "$STATE/qdisc"
+        ;;
+    "qdisc del")
+        if [ "$3" = root ]; then
+            grep -v " dev $2 " "$STATE/qdisc" > "$STATE/tmp"
+            : > "$STATE/filter"
+        else
+            grep -v " dev $2 parent $4 " "$STATE/qdisc" > "$STATE/tmp"
+        fi
+        mv "$STATE/tmp" "$STATE/qdisc"
+        ;;
+    "filter show")
+        while read proto prio addr classid; do
+            echo "filter parent 1beb: protocol $proto pref $prio flower chain 0 handle 0x1 classid $classid"
+            echo "  dst_ip $addr"
+        done < "$STATE/filter"
+        ;;
+    "filter del")
+        : > "$STATE/filter"
+        ;;
+    "filter add")
+        proto=$6 prio=$8 addr=${11} classid=${13}
+        if awk -v proto="$proto" -v prio="$prio" '$2 == prio && $1 != proto {exit 1}' "$STATE/filter"; then
+            echo "$proto $prio $addr $classid" >> "$STATE/filter"
+        else
+            echo "Error: Filter with specified priority/protocol not found." >&2
+            return 2
+        fi
+        ;;
+    esac
+}
+
+# Print the netem parameters of every degraded address.
+fake_netem() {
+    oc_blackhole_filters eth0 | while read addr band; do
+        echo "$addr $(awk -v parent="1beb:$band" '$2 == "netem" && $7 == parent {
+            for (i = 10; i <= NF; i++) printf "%s%s", $i, (i < NF ? " " : "")}' "$STATE/qdisc")"
+    done | sort
+}
+`
+
+// runNetemScript runs commands using the netem functions and the fake tc,
+// and returns the output.
+func runNetemScript(t *testing.T, state string, commands string) string {
+	cmd := exec.Command("sh", "-c", fakeTC+netemFunctions+commands)
+	cmd.Env = append(os.Environ(), "STATE="+state)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("script failed: %s: %s", err, out)
+	}
+	return string(out)
+}
+
+func TestNetemScriptTwoClusters(t *testing.T) {
+	state := t.TempDir()
+
+	// Dual stack clusters use ip and ipv6 filters.
+	runNetemScript(t, state, "oc_blackhole_degrade 'delay 100ms' 10.0.1.1 fd00::1\n")
+	runNetemScript(t, state, "oc_blackhole_degrade 'loss 5%' 10.0.2.1 fd00::2\n")
+
+	out := runNetemScript(t, state, "fake_netem\n")
+	expected := "10.0.1.1 delay 100ms\n10.0.2.1 loss 5%\nfd00::1 delay 100ms\nfd00::2 loss 5%\n"
+	if out != expected {
+		t.Errorf("expected netem:\n%s\ngot:\n%s", expected, out)
+	}
+
+	// Degrading again replaces only the cluster parameters.
+	runNetemScript(t, state, "oc_blackhole_degrade 'delay 200ms' 10.0.2.1 fd00::2\n")
+
+	out = runNetemScript(t, state, "fake_netem\n")
+	expected = "10.0.1.1 delay 100ms\n10.0.2.1 delay 200ms\nfd00::1 delay 100ms\nfd00::2 delay 200ms\n"
+	if out != expected {
+		t.Errorf("expected netem:\n%s\ngot:\n%s", expected, out)
+	}
+
+	// Restoring the second cluster keeps the first cluster parameters.
+	runNetemScript(t, state, "oc_blackhole_restore 10.0.2.1 fd00::2\n")
+
+	out = runNetemScript(t, state, "fake_netem; oc_blackhole_netem_bands eth0\n")
+	expected = "10.0.1.1 delay 100ms\nfd00::1 delay 100ms\n4\n"
+	if out != expected {
+		t.Errorf("expected netem:\n%s\ngot:\n%s", expected, out)
+	}
+
+	// Restoring the last cluster removes the qdisc.
+	runNetemScript(t, state, "oc_blackhole_restore 10.0.1.1 fd00::1\n")
+
+	if out := runNetemScript(t, state, "oc_blackhole_devs; fake_netem\n"); out != "" {
+		t.Errorf("unexpected state after restore: %q", out)
+	}
+}
@@ -0,0 +1,229 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// The nft blocking methods add the blocked addresses to sets in a dedicated
+// table. Packets from the node or forwarded by the node to addresses in the
+// drop sets are dropped silently, so connections time out like in a real
+// network partition. Packets to addresses in the reject sets are rejected,
+// so connections fail quickly.
+const nftTable = "inet oc_blackhole"
+
+type nftVerdict string
+
+const (
+	nftDrop   = nftVerdict("drop")
+	nftReject = nftVerdict("reject")
+)
+
+// nftSetupScript creates the table, or updates an existing table. Adding
+// existing sets and chains does not modify them, and the chains rules are
+// replaced, so this is idempotent.
+//
+// Every verdict has sets for addresses ("drop_v4"), addresses and protocols
+// ("drop_v4_proto"), and addresses, protocols and ports ("drop_v4_port").
+const nftSetupScript = `nft -f - <<'EOF'
+add table inet oc_blackhole
+add set inet oc_blackhole drop_v4 { type ipv4_addr; }
+add set inet oc_blackhole drop_v4_proto { type ipv4_addr . inet_proto; }
+add set inet oc_blackhole drop_v4_port { type ipv4_addr . inet_proto . inet_service; }
+add set inet oc_blackhole drop_v6 { type ipv6_addr; }
+add set inet oc_blackhole drop_v6_proto { type ipv6_addr . inet_proto; }
+add set inet oc_blackhole drop_v6_port { type ipv6_addr . inet_proto . inet_service; }
+add set inet oc_blackhole reject_v4 { type ipv4_addr; }
+add set inet oc_blackhole reject_v4_proto { type ipv4_addr . inet_proto; }
+add set inet oc_blackhole reject_v4_port { type ipv4_addr . inet_proto . inet_service; }
+add set inet oc_blackhole reject_v6 { type ipv6_addr; }
+add set inet oc_blackhole reject_v6_proto { type ipv6_addr . inet_proto; }
+add set inet oc_blackhole reject_v6_port { type ipv6_addr . inet_proto . inet_service; }
+add chain inet oc_blackhole output { type filter hook output priority 0; policy accept; }
+add chain inet oc_blackhole forward { type filter hook forward priority 0; policy accept; }
+add chain inet oc_blackhole blackhole
+flush chain inet oc_blackhole output
+flush chain inet oc_blackhole forward
+flush chain inet oc_blackhole blackhole
+add rule inet oc_blackhole output jump blackhole
+add rule inet oc_blackhole forward jump blackhole
+add rule inet oc_blackhole blackhole ip daddr @drop_v4 drop
+add rule inet oc_blackhole blackhole ip daddr . meta l4proto @drop_v4_proto drop
+add rule inet oc_blackhole blackhole ip daddr . meta l4proto . th dport @drop_v4_port drop
+add rule inet oc_blackhole blackhole ip6 daddr @drop_v6 drop
+add rule inet oc_blackhole blackhole ip6 daddr . meta l4proto @drop_v6_proto drop
+add rule inet oc_blackhole blackhole ip6 daddr . meta l4proto . th dport @drop_v6_port drop
+add rule inet oc_blackhole blackhole meta l4proto tcp ip daddr @reject_v4 reject with tcp reset
+add rule inet oc_blackhole blackhole meta l4proto tcp ip daddr . meta l4proto @reject_v4_proto reject with tcp reset
+add rule inet oc_blackhole blackhole meta l4proto tcp ip daddr . meta l4proto . th dport @reject_v4_port reject with tcp reset
+add rule inet oc_blackhole blackhole meta l4proto tcp ip6 daddr @reject_v6 reject with tcp reset
+add rule inet oc_blackhole blackhole meta l4proto tcp ip6 daddr . meta l4proto @reject_v6_proto reject with tcp reset
+add rule inet oc_blackhole blackhole meta l4proto tcp ip6 daddr . meta l4proto . th dport @reject_v6_port reject with tcp reset
+add rule inet oc_blackhole blackhole ip daddr @reject_v4 reject
+add rule inet oc_blackhole blackhole ip daddr . meta l4proto @reject_v4_proto reject
+add rule inet oc_blackhole blackhole ip daddr . meta l4proto . th dport @reject_v4_port reject
+add rule inet oc_blackhole blackhole ip6 daddr @reject_v6 reject
+add rule inet oc_blackhole blackhole ip6 daddr . meta l4proto @reject_v6_proto reject
+add rule inet oc_blackhole blackhole ip6 daddr . meta l4proto . th dport @reject_v6_port reject
+EOF
+`
+
+// nftListScript lists the table in JSON format. If the table does not exist
+// the output is empty.
+const nftListScript = "nft -j list table inet oc_blackhole 2>/dev/null || true\n"
+
+// nftClearScript deletes the table with all elements.
+const nftClearScript = "nft delete table inet oc_blackhole 2>/dev/null || true\n"
+
+// nftSet returns the name of the set for verdict and element. The element is
+// an address, or an address concatenated with protocol and port (see
+// PortFilter.Elements).
+func nftSet(verdict nftVerdict, element string) string {
+	parts := strings.Split(element, elementSeparator)
+
+	name := string(verdict) + "_v4"
+	if ip := net.ParseIP(parts[0]); ip != nil && ip.To4() == nil {
+		name = string(verdict) + "_v6"
+	}
+
+	switch len(parts) {
+	case 2:
+		name += "_proto"
+	case 3:
+		name += "_port"
+	}
+
+	return name
+}
+
+// nftElements groups elements by set name.
+func nftElements(verdict nftVerdict, elements []string) map[string][]string {
+	res := map[string][]string{}
+	for _, element := range elements {
+		set := nftSet(verdict, element)
+		res[set] = append(res[set], element)
+	}
+	return res
+}
+
+// addNftElementsScript returns a script adding elements to the verdict sets.
+// Adding existing elements is not an error.
+func addNftElementsScript(verdict nftVerdict, elements []string) string {
+	var sb strings.Builder
+	groups := nftElements(verdict, elements)
+	for _, set := range sets.List(sets.KeySet(groups)) {
+		fmt.Fprintf(&sb, "nft add element %s %s { %s }\n",
+			nftTable, set, strings.Join(groups[set], ", "))
+	}
+	return sb.String()
+}
+
+// deleteNftElementsScript returns a script deleting elements from the verdict
+// sets. Deleting a missing element fails, so errors are ignored, and the
+// script can run again after a partial failure.
+func deleteNftElementsScript(verdict nftVerdict, elements []string) string {
+	var sb strings.Builder
+	for _, element := range elements {
+		fmt.Fprintf(&sb, "nft delete element %s %s { %s } 2>/dev/null || true\n",
+			nftTable, nftSet(verdict, element), element)
+	}
+	return sb.String()
+}
+
+// expireNftCommands returns commands deleting elements from the verdict sets
+// when a block expires. The elements may have been deleted already.
+func expireNftCommands(verdict nftVerdict, elements []string) []string {
+	var res []string
+	for _, element := range elements {
+		res = append(res, fmt.Sprintf("nft delete element %s %s { %s } 2>/dev/null",
+			nftTable, nftSet(verdict, element), element))
+	}
+	return res
+}
+
+type nftOutput struct {
+	Nftables []struct {
+		Set *nftSetOutput `json:"set"`
+	} `json:"nftables"`
+}
+
+type nftSetOutput struct {
+	Name string            `json:"name"`
+	Elem []json.RawMessage `json:"elem"`
+}
+
+// parseNftSets returns the elements of every set in `nft -j list table`
+// output.
+func parseNftSets(out []byte) (map[string]sets.Set[string], error) {
+	res := map[string]sets.Set[string]{}
+
+	if len(strings.TrimSpace(string(out))) == 0 {
+		return res, nil
+	}
+
+	var output nftOutput
+	if err := json.Unmarshal(out, &output); err != nil {
+		return nil, fmt.Errorf("invalid nft output: %s", err)
+	}
+
+	for _, item := range output.Nftables {
+		if item.Set == nil {
+			continue
+		}
+
+		elements := sets.New[string]()
+		for _, raw := range item.Set.Elem {
+			element, err := parseNftElement(raw)
+			if err != nil {
+				return nil, err
+			}
+			elements.Insert(element)
+		}
+
+		res[item.Set.Name] = elements
+	}
+
+	return res, nil
+}
+
+// parseNftElement returns the string representation of a set element, like
+// "10.0.0.1" or "10.0.0.1 . tcp . 6443" for concatenations.
+func parseNftElement(raw json.RawMessage) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", err
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return fmt.Sprintf("%d", int64(v)), nil
+	case map[string]interface{}:
+		if concat, ok := v["concat"].([]interface{}); ok {
+			var parts []string
+			for _, part := range concat {
+				b, _ := json.Marshal(part)
+				s, err := parseNftElement(b)
+				if err != nil {
+					return "", err
+				}
+				parts = append(parts, s)
+			}
+			return strings.Join(parts, elementSeparator), nil
+		}
+		if elem, ok := v["elem"].(map[string]interface{}); ok {
+			b, _ := json.Marshal(elem["val"])
+			return parseNftElement(b)
+		}
+	}
+
+	return "", fmt.Errorf("unsupported nft element %s", raw)
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestNftElements(t *testing.T) {
+	elements := nftElements(nftDrop, []string{"10.0.0.1", "fd00::1", "10.0.0.2"})
+	expected := map[string][]string{
+		"drop_v4": {"10.0.0.1", "10.0.0.2"},
+		"drop_v6": {"fd00::1"},
+	}
+	if !reflect.DeepEqual(elements, expected) {
+		t.Errorf("expected %v, got %v", expected, elements)
+	}
+}
+
+func TestNftSet(t *testing.T) {
+	cases := map[string]string{
+		"10.0.0.1":             "drop_v4",
+		"fd00::1":              "drop_v6",
+		"10.0.0.1 . tcp":       "drop_v4_proto",
+		"fd00::1 . udp . 53":   "drop_v6_port",
+		"10.0.0.1 . tcp . 443": "drop_v4_port",
+	}
+
+	for element, expected := range cases {
+		if set := nftSet(nftDrop, element); set != expected {
+			t.Errorf("element %q: expected set %q, got %q", element, expected, set)
+		}
+	}
+}
+
+func TestAddNftElementsScript(t *testing.T) {
+	script := addNftElementsScript(nftReject, []string{"fd00::1", "10.0.0.1", "10.0.0.2"})
+	expected := "nft add element inet oc_blackhole reject_v4 { 10.0.0.1, 10.0.0.2 }\n" +
+		"nft add element inet oc_blackhole reject_v6 { fd00::1 }\n"
+	if script != expected {
+		t.Errorf("expected %q, got %q", expected, script)
+	}
+}
+
+func TestParseNftSetsEmpty(t *testing.T) {
+	res, err := parseNftSets([]byte("\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 0 {
+		t.Errorf("unexpected sets %v", res)
+	}
+}
+
+func TestParseNftSetsElements(t *testing.T) {
+	out := `{"nftables": [
+{"set": {"name": "drop_v4", "elem": [
+  "10.0.0.1",
+  {"elem": {"val": "10.0.0.2", "timeout": 60}},
+  {"concat": ["10.0.0.3", "tcp", 6443]}
+]}},
+{"set": {"name": "reject_v4"}}
+]}`
+	res, err := parseNftSets([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]sets.Set[string]{
+		"drop_v4":   sets.New("10.0.0.1", "10.0.0.2", "10.0.0.3 . tcp . 6443"),
+		"reject_v4": sets.New[string](),
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected %v, got %v", expected, res)
+	}
+}
+
+func TestParseNftSetsInvalid(t *testing.T) {
+	cases := []string{
+		"not json",
+		`{"nftables": [{"set": {"name": "drop_v4", "elem": [true]}}]}`,
+	}
+
+	for _, out := range cases {
+		if res, err := parseNftSets([]byte(out)); err == nil {
+			t.Errorf("parsing %q did not fail: %v", out, res)
+		}
+	}
+}
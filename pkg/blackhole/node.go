@@ -0,0 +1,437 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+type BlockMethod string
+
+const (
+	// Add blackhole routes; connecting fails immediately with "Invalid
+	// argument".
+	MethodRoute = BlockMethod("route")
+
+	// Drop packets using nftables; connecting times out.
+	MethodNftDrop = BlockMethod("nft-drop")
+
+	// Reject packets using nftables; connecting fails immediately with
+	// "Connection refused".
+	MethodNftReject = BlockMethod("nft-reject")
+)
+
+// checkMethodFilter fails if method cannot block traffic matching filter.
+func checkMethodFilter(method BlockMethod, filter PortFilter) error {
+	if method == MethodRoute && !filter.IsEmpty() {
+		return fmt.Errorf("blocking ports or protocol requires nft-drop or nft-reject method")
+	}
+	return nil
+}
+
+// ParseBlockMethod returns the method named name.
+func ParseBlockMethod(name string) (BlockMethod, error) {
+	switch method := BlockMethod(name); method {
+	case MethodRoute, MethodNftDrop, MethodNftReject:
+		return method, nil
+	default:
+		return "", fmt.Errorf("unknown block method %q", name)
+	}
+}
+
+// nodeState describes the changes on a target node.
+type nodeState struct {
+	// Addresses with blackhole routes installed by us.
+	Routes sets.Set[string]
+
+	// Addresses with blackhole routes installed by others, which we never
+	// modify.
+	ForeignRoutes sets.Set[string]
+
+	// Addresses of foreign routes using the default protocol, which may have
+	// been added by older versions. These are deleted only when unblocking.
+	LegacyRoutes sets.Set[string]
+
+	// Elements dropped by nftables: addresses, or addresses with protocol
+	// and port like "10.0.0.1 . tcp . 6443".
+	Dropped sets.Set[string]
+
+	// Elements rejected by nftables.
+	Rejected sets.Set[string]
+
+	// Addresses with degraded network.
+	Degraded sets.Set[string]
+}
+
+// Blocked returns the addresses and elements blocked by any method.
+func (s *nodeState) Blocked() sets.Set[string] {
+	return s.Routes.Union(s.Dropped).Union(s.Rejected)
+}
+
+// The node state script output is split to sections, each starting with a
+// section header line.
+const (
+	routesSection = "[routes]"
+	nftSection    = "[nft]"
+	netemSection  = "[netem]"
+)
+
+var nodeStateScript = netemFunctions +
+	"echo '" + routesSection + "'\n" +
+	blackholeRoutesScript +
+	"echo '" + nftSection + "'\n" +
+	nftListScript +
+	"echo '" + netemSection + "'\n" +
+	"oc_blackhole_netem_show\n"
+
+// The block with state script output starts with the node state, followed by
+// the block section with the block script output, and the status section with
+// the block script exit status.
+const (
+	blockSection  = "[block]"
+	statusSection = "[status]"
+)
+
+// blockNode blocks traffic to addresses matching filter on the node using
+// method. If expire is not zero, the node unblocks the addresses using the
+// systemd unit after expire. Otherwise previous expiry scheduled by unit is
+// cancelled.
+func blockNode(
+	ctx context.Context,
+	executor nodeExecutor,
+	context string,
+	nodeName string,
+	method BlockMethod,
+	addresses []string,
+	filter PortFilter,
+	unit string,
+	expire time.Duration,
+) error {
+	dbglog(ctx).Printf("blocking addresses (%s) in node %s using %s", filter, nodeName, method)
+
+	script, err := blockScript(method, addresses, filter, unit, expire)
+	if err != nil {
+		return err
+	}
+
+	_, err = executor.Run(ctx, context, nodeName, script)
+	return err
+}
+
+// blockNodeWithState blocks the node like blockNode, and returns the node
+// state before blocking, inspected by the same script. If blocking fails after
+// the node was inspected, the state is returned with the error, so the changes
+// can be rolled back.
+func blockNodeWithState(
+	ctx context.Context,
+	executor nodeExecutor,
+	context string,
+	nodeName string,
+	method BlockMethod,
+	addresses []string,
+	filter PortFilter,
+	unit string,
+	expire time.Duration,
+) (*nodeState, error) {
+	dbglog(ctx).Printf("blocking addresses (%s) in node %s using %s", filter, nodeName, method)
+
+	script, err := blockScript(method, addresses, filter, unit, expire)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := executor.Run(ctx, context, nodeName, blockWithStateScript(script))
+	if err != nil {
+		return nil, err
+	}
+
+	stateOut, blockOut, ok := bytes.Cut(out, []byte(blockSection+"\n"))
+	if !ok {
+		return nil, fmt.Errorf("missing block output on cluster %q node %q", context, nodeName)
+	}
+
+	state, err := parseNodeState(stateOut)
+	if err != nil {
+		return nil, fmt.Errorf("%s on cluster %q node %q", err, context, nodeName)
+	}
+
+	blockOut, status, _ := bytes.Cut(blockOut, []byte(statusSection+"\n"))
+	if status := strings.TrimSpace(string(status)); status != "0" {
+		return state, fmt.Errorf("blocking failed on cluster %q node %q with exit status %q: %s",
+			context, nodeName, status, bytes.TrimSpace(blockOut))
+	}
+
+	return state, nil
+}
+
+// blockWithStateScript returns a script printing the node state, and running
+// the block script in a subshell stopping on the first error. The script
+// succeeds even if blocking fails, so the state is available for rollback.
+func blockWithStateScript(script string) string {
+	return nodeStateScript +
+		"echo '" + blockSection + "'\n" +
+		"(\n" +
+		"set -e\n" +
+		script +
+		") 2>&1\n" +
+		"status=$?\n" +
+		"echo '" + statusSection + "'\n" +
+		"echo $status\n"
+}
+
+// blockScript returns a script blocking traffic to addresses matching filter
+// using method, and scheduling expiry using unit if expire is not zero.
+func blockScript(method BlockMethod, addresses []string, filter PortFilter, unit string, expire time.Duration) (string, error) {
+	if err := checkMethodFilter(method, filter); err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	var expireCommands []string
+
+	sb.WriteString(cancelExpiryScript(unit))
+
+	switch method {
+	case MethodRoute:
+		sb.WriteString(addRoutesScript(addresses))
+		expireCommands = expireRoutesCommands(addresses)
+	case MethodNftDrop, MethodNftReject:
+		verdict, other := nftDrop, nftReject
+		if method == MethodNftReject {
+			verdict, other = nftReject, nftDrop
+		}
+		elements := filter.Elements(addresses)
+		sb.WriteString(nftSetupScript)
+		sb.WriteString(addNftElementsScript(verdict, elements))
+		// Switching between drop and reject.
+		for _, command := range expireNftCommands(other, elements) {
+			sb.WriteString(command + " || true\n")
+		}
+		expireCommands = expireNftCommands(verdict, elements)
+	default:
+		return "", fmt.Errorf("unknown block method %q", method)
+	}
+
+	if expire > 0 {
+		sb.WriteString(scheduleExpiryScript(unit, expireCommands, expire))
+	}
+
+	return sb.String(), nil
+}
+
+// unblockNode unblocks traffic to addresses matching filter blocked by any
+// method on the node, and cancels expiry scheduled by unit. If filter is
+// empty, all blocks of the addresses are removed, including blocks limited to
+// some protocols or ports. If legacy is true, unmarked blackhole routes added
+// by older versions for addresses are also removed.
+func unblockNode(
+	ctx context.Context,
+	executor nodeExecutor,
+	context string,
+	nodeName string,
+	addresses []string,
+	filter PortFilter,
+	unit string,
+	legacy bool,
+) error {
+	dbglog(ctx).Printf("unblocking addresses (%s) in node %s", filter, nodeName)
+
+	state, err := inspectNode(ctx, executor, context, nodeName)
+	if err != nil {
+		return err
+	}
+
+	script := unblockScript(state, addresses, filter, unit, legacy)
+	if script == cancelExpiryScript(unit) {
+		dbglog(ctx).Printf("No address to unblock on node %s", nodeName)
+	}
+
+	_, err = executor.Run(ctx, context, nodeName, script)
+	return err
+}
+
+// unblockScript returns a script deleting the blocks in state of addresses
+// matching filter, and cancelling expiry scheduled by unit. The blocks may
+// be removed before the script runs, for example by a retried attempt or by
+// the expiry timer, so missing blocks are ignored. If legacy is true, unmarked
+// routes for addresses are deleted after our marked routes.
+func unblockScript(state *nodeState, addresses []string, filter PortFilter, unit string, legacy bool) string {
+	var sb strings.Builder
+
+	if filter.IsEmpty() {
+		sb.WriteString(deleteRoutesScript(filterAddresses(addresses, state.Routes)))
+		if legacy {
+			sb.WriteString(deleteLegacyRoutesScript(filterAddresses(addresses, state.LegacyRoutes)))
+		}
+		sb.WriteString(deleteNftElementsScript(nftDrop, addressElements(addresses, state.Dropped)))
+		sb.WriteString(deleteNftElementsScript(nftReject, addressElements(addresses, state.Rejected)))
+	} else {
+		elements := filter.Elements(addresses)
+		sb.WriteString(deleteNftElementsScript(nftDrop, filterAddresses(elements, state.Dropped)))
+		sb.WriteString(deleteNftElementsScript(nftReject, filterAddresses(elements, state.Rejected)))
+	}
+
+	sb.WriteString(cancelExpiryScript(unit))
+	return sb.String()
+}
+
+// unblockAllNode removes all blocks on the node for any blocked cluster:
+// blackhole routes installed by us, nftables table, and scheduled expiry.
+func unblockAllNode(ctx context.Context, executor nodeExecutor, context string, nodeName string) error {
+	dbglog(ctx).Printf("unblocking all addresses in node %s", nodeName)
+
+	state, err := inspectNode(ctx, executor, context, nodeName)
+	if err != nil {
+		return err
+	}
+
+	_, err = executor.Run(ctx, context, nodeName, unblockAllScript(state))
+	return err
+}
+
+// clearNode removes all changes on the node: blackhole routes, nftables
+// table, network degradation, and scheduled expiry.
+func clearNode(ctx context.Context, executor nodeExecutor, context string, nodeName string) error {
+	dbglog(ctx).Printf("clearing node %s", nodeName)
+
+	state, err := inspectNode(ctx, executor, context, nodeName)
+	if err != nil {
+		return err
+	}
+
+	script := unblockAllScript(state) +
+		netemFunctions +
+		"oc_blackhole_restore_all\n"
+
+	_, err = executor.Run(ctx, context, nodeName, script)
+	return err
+}
+
+// unblockAllScript returns a script removing all blocks in state, including
+// unmarked routes, since we cannot tell which were added by older versions.
+func unblockAllScript(state *nodeState) string {
+	return cancelExpiryScript(expiryUnitPrefix+"*") +
+		deleteRoutesScript(sets.List(state.Routes)) +
+		deleteLegacyRoutesScript(sets.List(state.LegacyRoutes)) +
+		nftClearScript
+}
+
+// filterAddresses returns the addresses in set.
+func filterAddresses(addresses []string, set sets.Set[string]) []string {
+	var res []string
+	for _, address := range addresses {
+		if set.Has(address) {
+			res = append(res, address)
+		}
+	}
+	return res
+}
+
+// addressElements returns the elements in set for any of addresses.
+func addressElements(addresses []string, set sets.Set[string]) []string {
+	wanted := sets.New(addresses...)
+	var res []string
+	for _, element := range sets.List(set) {
+		if wanted.Has(elementAddress(element)) {
+			res = append(res, element)
+		}
+	}
+	return res
+}
+
+// inspectNode returns the state of a target node.
+func inspectNode(ctx context.Context, executor nodeExecutor, context string, nodeName string) (*nodeState, error) {
+	dbglog(ctx).Printf("Inspecting node %s", nodeName)
+
+	out, err := executor.Run(ctx, context, nodeName, nodeStateScript)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := parseNodeState(out)
+	if err != nil {
+		return nil, fmt.Errorf("%s on cluster %q node %q", err, context, nodeName)
+	}
+
+	return state, nil
+}
+
+func parseNodeState(out []byte) (*nodeState, error) {
+	state := &nodeState{
+		Routes:        sets.New[string](),
+		ForeignRoutes: sets.New[string](),
+		LegacyRoutes:  sets.New[string](),
+		Dropped:       sets.New[string](),
+		Rejected:      sets.New[string](),
+		Degraded:      sets.New[string](),
+	}
+
+	var section string
+	var nftOut bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+
+	// nft JSON output is a single line, which may be very long.
+	scanner.Buffer(nil, 16*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch strings.TrimSpace(line) {
+		case routesSection, nftSection, netemSection:
+			section = strings.TrimSpace(line)
+			continue
+		case "":
+			continue
+		}
+
+		switch section {
+		case routesSection:
+			address, proto, err := parseBlackholeRoute(line)
+			if err != nil {
+				return nil, err
+			}
+			switch proto {
+			case routeProto:
+				state.Routes.Insert(address)
+			case "":
+				state.ForeignRoutes.Insert(address)
+				state.LegacyRoutes.Insert(address)
+			default:
+				state.ForeignRoutes.Insert(address)
+			}
+		case nftSection:
+			nftOut.WriteString(line + "\n")
+		case netemSection:
+			state.Degraded.Insert(strings.TrimSpace(line))
+		default:
+			return nil, fmt.Errorf("unexpected output %q", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	nftSets, err := parseNftSets(nftOut.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	for name, elements := range nftSets {
+		switch {
+		case strings.HasPrefix(name, string(nftDrop)+"_"):
+			state.Dropped = state.Dropped.Union(elements)
+		case strings.HasPrefix(name, string(nftReject)+"_"):
+			state.Rejected = state.Rejected.Union(elements)
+		}
+	}
+
+	return state, nil
+}
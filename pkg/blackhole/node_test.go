@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestParseNodeState(t *testing.T) {
+	out := `[routes]
+blackhole 10.0.0.1 proto 143 metric 1 
+blackhole 10.0.0.3 
+blackhole 2a00:1450:4028:809::200e dev lo proto 143 metric 1 pref medium 
+blackhole 2a00:1450:4028:809::200f dev lo metric 1024 pref medium 
+[netem]
+10.0.0.2
+`
+	state, err := parseNodeState([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := sets.New("10.0.0.1", "2a00:1450:4028:809::200e"); !state.Routes.Equal(expected) {
+		t.Errorf("expected routes %v, got %v", sets.List(expected), sets.List(state.Routes))
+	}
+	if expected := sets.New("10.0.0.3", "2a00:1450:4028:809::200f"); !state.ForeignRoutes.Equal(expected) {
+		t.Errorf("expected foreign routes %v, got %v", sets.List(expected), sets.List(state.ForeignRoutes))
+	}
+	if expected := sets.New("10.0.0.2"); !state.Degraded.Equal(expected) {
+		t.Errorf("expected degraded %v, got %v", sets.List(expected), sets.List(state.Degraded))
+	}
+}
+
+func TestParseNodeStateNft(t *testing.T) {
+	out := `[routes]
+[nft]
+{"nftables": [{"metainfo": {"version": "1.0.9"}}, {"table": {"family": "inet", "name": "oc_blackhole"}}, {"set": {"family": "inet", "name": "drop_v4", "table": "oc_blackhole", "type": "ipv4_addr", "elem": ["10.0.0.1", "10.0.0.2"]}}, {"set": {"family": "inet", "name": "drop_v6", "table": "oc_blackhole", "type": "ipv6_addr", "elem": ["fd00::1"]}}, {"set": {"family": "inet", "name": "reject_v4", "table": "oc_blackhole", "type": "ipv4_addr"}}, {"set": {"family": "inet", "name": "reject_v6", "table": "oc_blackhole", "type": "ipv6_addr", "elem": ["fd00::2"]}}]}
+[netem]
+`
+	state, err := parseNodeState([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := sets.New("10.0.0.1", "10.0.0.2", "fd00::1"); !state.Dropped.Equal(expected) {
+		t.Errorf("expected dropped %v, got %v", sets.List(expected), sets.List(state.Dropped))
+	}
+	if expected := sets.New("fd00::2"); !state.Rejected.Equal(expected) {
+		t.Errorf("expected rejected %v, got %v", sets.List(expected), sets.List(state.Rejected))
+	}
+	if expected := sets.New("10.0.0.1", "10.0.0.2", "fd00::1", "fd00::2"); !state.Blocked().Equal(expected) {
+		t.Errorf("expected blocked %v, got %v", sets.List(expected), sets.List(state.Blocked()))
+	}
+}
+
+func TestParseNodeStateEmpty(t *testing.T) {
+	state, err := parseNodeState([]byte("[routes]\n[netem]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Routes.Len() != 0 || state.Degraded.Len() != 0 {
+		t.Errorf("unexpected state %+v", state)
+	}
+}
+
+func TestParseNodeStateInvalid(t *testing.T) {
+	cases := []string{
+		"unexpected\n",
+		"[routes]\nunreachable 10.0.0.1\n",
+		"[nft]\n{invalid json\n",
+	}
+
+	for _, out := range cases {
+		if state, err := parseNodeState([]byte(out)); err == nil {
+			t.Errorf("parsing %q did not fail: %+v", out, state)
+		}
+	}
+}
+
+func TestBlockNodeWithState(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.AddRoutes("hub", "hub-1", "10.0.0.1")
+
+	state, err := blockNodeWithState(context.TODO(), executor, "hub", "hub-1",
+		MethodRoute, []string{"10.0.0.1", "10.0.0.2"}, PortFilter{}, "unit", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The state before blocking.
+	if expected := sets.New("10.0.0.1"); !state.Routes.Equal(expected) {
+		t.Errorf("expected routes %v, got %v", sets.List(expected), sets.List(state.Routes))
+	}
+	if expected := sets.New("10.0.0.1", "10.0.0.2"); !executor.Routes("hub", "hub-1").Equal(expected) {
+		t.Errorf("expected routes %v, got %v", sets.List(expected), sets.List(executor.Routes("hub", "hub-1")))
+	}
+	if runs := executor.Runs("hub", "hub-1"); runs != 1 {
+		t.Errorf("expected 1 run, got %d", runs)
+	}
+}
+
+func TestBlockNodeWithStateFailure(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.FailCommand("hub", "hub-1", "ip route replace blackhole 10.0.0.2 ")
+
+	state, err := blockNodeWithState(context.TODO(), executor, "hub", "hub-1",
+		MethodRoute, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, PortFilter{}, "unit", 0)
+	if err == nil {
+		t.Fatal("failing block did not fail")
+	}
+
+	// The state was inspected before blocking failed.
+	if state == nil || state.Routes.Len() != 0 {
+		t.Fatalf("unexpected state %+v", state)
+	}
+
+	// Blocking stopped on the first error.
+	if expected := sets.New("10.0.0.1"); !executor.Routes("hub", "hub-1").Equal(expected) {
+		t.Errorf("expected routes %v, got %v", sets.List(expected), sets.List(executor.Routes("hub", "hub-1")))
+	}
+}
@@ -0,0 +1,196 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Defaults used by the oc-blackhole command. Options zero values mean no
+// timeout, no retries, and no limit.
+const (
+	DefaultNodeTimeout = 5 * time.Minute
+	DefaultRetries     = 2
+	DefaultParallel    = 20
+)
+
+// Options describe how to access the clusters and how to modify the target
+// nodes.
+type Options struct {
+	// The kubeconfig file with the clusters contexts. If empty, use the
+	// default kubeconfig ($KUBECONFIG or ~/.kube/config). Used only for
+	// contexts missing in Configs.
+	Kubeconfig string
+
+	// Rest configs per context, for callers without a kubeconfig file.
+	Configs map[string]*rest.Config
+
+	// The contexts of the target clusters.
+	Targets []string
+
+	// How to block the cluster addresses. If empty, use MethodRoute. Blocking
+	// with an unknown method is an error.
+	Method BlockMethod
+
+	// Block, unblock, or inspect only traffic matching the filter.
+	Filter PortFilter
+
+	// The cluster address categories to modify or inspect. If empty, use all
+	// categories.
+	Categories []AddressCategory
+
+	// If not zero, the target nodes unblock the cluster after Expire, even if
+	// the caller was terminated.
+	Expire time.Duration
+
+	// If true, nodes blocked before a failure are kept blocked.
+	NoRollback bool
+
+	// How to run commands on target nodes (oc-debug, pod, agent). If empty,
+	// use oc-debug.
+	Executor string
+
+	// Cancel a command on a node if it takes more than NodeTimeout. Zero
+	// means no timeout.
+	NodeTimeout time.Duration
+
+	// Retry commands failing with a transient error up to Retries times.
+	Retries int
+
+	// Maximum number of nodes modified or inspected concurrently, globally
+	// and per target. Zero means no limit.
+	Parallel          int
+	ParallelPerTarget int
+
+	// Loggers for debug messages and for errors that cannot be returned. If
+	// nil, debug messages are discarded and errors are logged using the
+	// standard logger.
+	Logger      *log.Logger
+	ErrorLogger *log.Logger
+
+	// Called when the progress of an operation changes.
+	Progress func(ProgressUpdate)
+
+	// If set, a progress line is written to ProgressOutput, like the
+	// oc-blackhole --progress flag.
+	ProgressOutput io.Writer
+}
+
+// context returns a context using the options loggers.
+func (o *Options) context(ctx context.Context) context.Context {
+	if o.Logger != nil {
+		ctx = WithLogger(ctx, o.Logger)
+	}
+	if o.ErrorLogger != nil {
+		ctx = WithErrorLogger(ctx, o.ErrorLogger)
+	}
+	return ctx
+}
+
+// restConfigs returns the rest configs for contexts. The kubeconfig is loaded
+// only if some context is missing in Configs.
+func (o *Options) restConfigs(contexts []string) (map[string]*rest.Config, error) {
+	res := map[string]*rest.Config{}
+	var config *api.Config
+
+	for _, context := range contexts {
+		if restConfig, ok := o.Configs[context]; ok {
+			res[context] = restConfig
+			continue
+		}
+
+		if config == nil {
+			var err error
+			config, err = o.kubeconfig()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		restConfig, err := createRestConfig(config, context)
+		if err != nil {
+			return nil, err
+		}
+		res[context] = restConfig
+	}
+
+	return res, nil
+}
+
+// contexts returns all contexts in Configs and in the kubeconfig. If only
+// Configs were specified, the kubeconfig is not used.
+func (o *Options) contexts() ([]string, error) {
+	res := sets.KeySet(o.Configs)
+
+	if len(o.Configs) == 0 || o.Kubeconfig != "" {
+		config, err := o.kubeconfig()
+		if err != nil {
+			return nil, err
+		}
+		res.Insert(sets.List(sets.KeySet(config.Contexts))...)
+	}
+
+	return sets.List(res), nil
+}
+
+func (o *Options) kubeconfig() (*api.Config, error) {
+	if o.Kubeconfig != "" {
+		return clientcmd.LoadFromFile(o.Kubeconfig)
+	}
+	return clientcmd.NewDefaultClientConfigLoadingRules().Load()
+}
+
+func (o *Options) pool() *nodePool {
+	return newNodePool(o.Parallel, o.ParallelPerTarget)
+}
+
+// progress returns a new progress reporting to the options progress output
+// and callback.
+func (o *Options) progress() *progressReporter {
+	out := o.ProgressOutput
+	if out == nil {
+		out = io.Discard
+	}
+	// We start with inderminate progress, since we don't know yet the number
+	// of nodes.
+	progress := newProgressReporter("setting up", 0, out)
+	progress.Notify(o.Progress)
+	return progress
+}
+
+// checkBlock returns an error if the options method or filter are invalid, or
+// if the filter cannot be used with the method.
+func (o *Options) checkBlock() error {
+	if o.Method != "" {
+		if _, err := ParseBlockMethod(string(o.Method)); err != nil {
+			return err
+		}
+	}
+	if err := o.Filter.check(); err != nil {
+		return err
+	}
+	return checkMethodFilter(o.blockOptions().Method, o.Filter)
+}
+
+// blockOptions returns the block options of the options.
+func (o *Options) blockOptions() *blockOptions {
+	method := o.Method
+	if method == "" {
+		method = MethodRoute
+	}
+	return &blockOptions{
+		Method:     method,
+		Filter:     o.Filter,
+		Expire:     o.Expire,
+		NoRollback: o.NoRollback,
+	}
+}
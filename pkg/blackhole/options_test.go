@@ -0,0 +1,238 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: hub-cluster
+  cluster:
+    server: https://api.hub.example.com:6443
+- name: dr1-cluster
+  cluster:
+    server: https://api.dr1.example.com:6443
+contexts:
+- name: hub
+  context:
+    cluster: hub-cluster
+- name: dr1
+  context:
+    cluster: dr1-cluster
+current-context: hub
+`
+
+func writeKubeconfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOptionsRestConfigs(t *testing.T) {
+	options := &Options{
+		Kubeconfig: writeKubeconfig(t),
+		Configs: map[string]*rest.Config{
+			"dr2": {Host: "api.dr2.example.com:6443"},
+		},
+	}
+
+	configs, err := options.restConfigs([]string{"dr1", "dr2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if host := configs["dr1"].Host; host != "https://api.dr1.example.com:6443" {
+		t.Errorf("unexpected dr1 host %q", host)
+	}
+	if configs["dr2"] != options.Configs["dr2"] {
+		t.Errorf("dr2 config not used: %+v", configs["dr2"])
+	}
+
+	if _, err := options.restConfigs([]string{"missing"}); err == nil {
+		t.Error("missing context did not fail")
+	}
+}
+
+func TestOptionsRestConfigsWithoutKubeconfig(t *testing.T) {
+	options := &Options{
+		Kubeconfig: filepath.Join(t.TempDir(), "missing"),
+		Configs: map[string]*rest.Config{
+			"dr1": {Host: "https://api.dr1.example.com:6443"},
+		},
+	}
+
+	// The kubeconfig is not loaded if all contexts have a rest config.
+	if _, err := options.restConfigs([]string{"dr1"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOptionsContexts(t *testing.T) {
+	configs := map[string]*rest.Config{"dr2": {Host: "api.dr2.example.com:6443"}}
+
+	options := &Options{Kubeconfig: writeKubeconfig(t), Configs: configs}
+	contexts, err := options.contexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"dr1", "dr2", "hub"}; !reflect.DeepEqual(contexts, expected) {
+		t.Errorf("expected contexts %v, got %v", expected, contexts)
+	}
+
+	options = &Options{Configs: configs}
+	contexts, err = options.contexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"dr2"}; !reflect.DeepEqual(contexts, expected) {
+		t.Errorf("expected contexts %v, got %v", expected, contexts)
+	}
+}
+
+func TestNewCommandOptions(t *testing.T) {
+	options := &Options{
+		Kubeconfig: writeKubeconfig(t),
+		Configs: map[string]*rest.Config{
+			"dr2": {Host: "api.dr2.example.com:6443"},
+		},
+		Targets:  []string{"hub", "dr2"},
+		Executor: ExecutorPod,
+	}
+
+	c, err := newCommand("dr1", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Cluster.Context != "dr1" || c.Cluster.server != "https://api.dr1.example.com:6443" {
+		t.Errorf("unexpected cluster %q server %q", c.Cluster.Context, c.Cluster.server)
+	}
+	if len(c.Targets) != 2 || c.Targets[0].Context != "hub" || c.Targets[1].Context != "dr2" {
+		t.Errorf("unexpected targets %+v", c.Targets)
+	}
+	if !reflect.DeepEqual(c.only, AllCategories) {
+		t.Errorf("expected all categories, got %v", c.only)
+	}
+	retry, ok := c.executor.(*retryExecutor)
+	if !ok {
+		t.Fatalf("unexpected executor %T", c.executor)
+	}
+	if _, ok := retry.Executor.(*podExecutor); !ok {
+		t.Errorf("expected pod executor, got %T", retry.Executor)
+	}
+}
+
+func TestNewCommandInvalidOptions(t *testing.T) {
+	kubeconfig := writeKubeconfig(t)
+
+	cases := map[string]*Options{
+		"blocked in targets": {Kubeconfig: kubeconfig, Targets: []string{"hub", "dr1"}},
+		"duplicate targets":  {Kubeconfig: kubeconfig, Targets: []string{"hub", "hub"}},
+		"missing target":     {Kubeconfig: kubeconfig, Targets: []string{"missing"}},
+		"unknown executor":   {Kubeconfig: kubeconfig, Targets: []string{"hub"}, Executor: "ssh"},
+	}
+
+	for name, options := range cases {
+		if _, err := newCommand("dr1", options); err == nil {
+			t.Errorf("%s: did not fail", name)
+		}
+	}
+}
+
+func TestBlockInvalidOptions(t *testing.T) {
+	kubeconfig := writeKubeconfig(t)
+
+	cases := map[string]*Options{
+		"unknown method":   {Method: "bogus"},
+		"route filter":     {Filter: PortFilter{Protocol: ProtocolTCP, Ports: []uint16{443}}},
+		"unknown protocol": {Method: MethodNftDrop, Filter: PortFilter{Protocol: "icmp"}},
+		"invalid port":     {Method: MethodNftDrop, Filter: PortFilter{Protocol: ProtocolTCP, Ports: []uint16{0}}},
+	}
+
+	// The options are validated before accessing the clusters, so a cancelled
+	// context does not hide the error.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for name, options := range cases {
+		options.Kubeconfig = kubeconfig
+		options.Targets = []string{"hub"}
+		_, err := Block(ctx, "dr1", options)
+		if err == nil {
+			t.Errorf("%s: did not fail", name)
+		} else if errors.Is(err, context.Canceled) {
+			t.Errorf("%s: accessed the clusters: %s", name, err)
+		}
+	}
+}
+
+func TestOptionsBlockOptions(t *testing.T) {
+	options := &Options{}
+	if method := options.blockOptions().Method; method != MethodRoute {
+		t.Errorf("expected default method %q, got %q", MethodRoute, method)
+	}
+
+	options.Method = MethodNftReject
+	if method := options.blockOptions().Method; method != MethodNftReject {
+		t.Errorf("expected method %q, got %q", MethodNftReject, method)
+	}
+}
+
+func TestOptionsProgress(t *testing.T) {
+	var updates []ProgressUpdate
+	var out bytes.Buffer
+
+	options := &Options{
+		Progress:       func(update ProgressUpdate) { updates = append(updates, update) },
+		ProgressOutput: &out,
+	}
+
+	progress := options.progress()
+	progress.SetTasks(3)
+	progress.Start(2)
+	progress.Add(1)
+
+	last := updates[len(updates)-1]
+	expected := ProgressUpdate{Description: "setting up", Tasks: 3, Queued: 1, Running: 1, Done: 1}
+	if last != expected {
+		t.Errorf("expected update %+v, got %+v", expected, last)
+	}
+	if out.Len() == 0 {
+		t.Error("progress not written to output")
+	}
+}
+
+func TestOptionsLoggers(t *testing.T) {
+	var debug, errors bytes.Buffer
+	options := &Options{
+		Logger:      log.New(&debug, "", 0),
+		ErrorLogger: log.New(&errors, "", 0),
+	}
+
+	ctx := options.context(context.Background())
+	dbglog(ctx).Print("debug")
+	errlog(ctx).Print("error")
+
+	if debug.String() != "debug\n" || errors.String() != "error\n" {
+		t.Errorf("unexpected logs %q %q", debug.String(), errors.String())
+	}
+
+	if dbglog(context.Background()) != discardLogger {
+		t.Error("debug messages are not discarded by default")
+	}
+}
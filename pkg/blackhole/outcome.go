@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Operations on target nodes wait until all nodes are done, and report the
+// outcome of every node, so a failure on one node does not hide failures on
+// other nodes.
+
+type NodeResult string
+
+const (
+	// The node was modified or inspected.
+	ResultDone = NodeResult("done")
+
+	// Modifying or inspecting the node failed.
+	ResultFailed = NodeResult("failed")
+
+	// The node was not started because the operation was cancelled.
+	ResultSkipped = NodeResult("skipped")
+
+	// The node was modified, and the change was undone.
+	ResultRolledBack = NodeResult("rolled-back")
+
+	// The node was modified, and undoing the change failed.
+	ResultRollbackFailed = NodeResult("rollback-failed")
+)
+
+// NodeOutcome describes the result of modifying or inspecting a node.
+type NodeOutcome struct {
+	Target string
+	Node   string
+	Result NodeResult
+	Err    error
+}
+
+// NodesError is returned when an operation failed on some nodes.
+type NodesError struct {
+	// The failed operation, like `unblocking cluster "foo"`.
+	Operation string
+
+	// Outcome of every target node, ordered by target and node.
+	Outcomes []NodeOutcome
+}
+
+func (e *NodesError) Error() string {
+	counts := countResults(e.Outcomes)
+	return fmt.Sprintf("%s failed on %d of %d nodes, %d done, %d skipped: %s",
+		e.Operation, counts[ResultFailed], len(e.Outcomes), counts[ResultDone], counts[ResultSkipped],
+		outcomeErrors(e.Outcomes))
+}
+
+// Unwrap returns the errors of all failed nodes.
+func (e *NodesError) Unwrap() []error {
+	var errs []error
+	for _, outcome := range e.Outcomes {
+		if outcome.Err != nil {
+			errs = append(errs, outcome.Err)
+		}
+	}
+	return errs
+}
+
+// Write writes the outcome of every node as a table.
+func (e *NodesError) Write(out io.Writer) error {
+	return writeOutcomes(out, e.Outcomes)
+}
+
+// failed returns true if some nodes failed.
+func failed(outcomes []NodeOutcome) bool {
+	for _, outcome := range outcomes {
+		if outcome.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// countResults returns the number of nodes with every result.
+func countResults(outcomes []NodeOutcome) map[NodeResult]int {
+	counts := map[NodeResult]int{}
+	for _, outcome := range outcomes {
+		counts[outcome.Result]++
+	}
+	return counts
+}
+
+// outcomeErrors returns the errors of the failed nodes, like
+// "hub/hub-1: error; hub/hub-2: error". The reason for skipping nodes is
+// reported once.
+func outcomeErrors(outcomes []NodeOutcome) string {
+	var msgs []string
+	var skipped error
+	for _, outcome := range outcomes {
+		if outcome.Err == nil {
+			continue
+		}
+		if outcome.Result == ResultSkipped {
+			if skipped == nil {
+				skipped = outcome.Err
+			}
+			continue
+		}
+		msgs = append(msgs, fmt.Sprintf("%s/%s: %s", outcome.Target, outcome.Node, outcome.Err))
+	}
+	if skipped != nil {
+		msgs = append(msgs, fmt.Sprintf("skipped: %s", skipped))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// writeOutcomes writes the outcome of every node as a table.
+func writeOutcomes(out io.Writer, outcomes []NodeOutcome) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tNODE\tRESULT\tERROR")
+	for _, outcome := range outcomes {
+		msg := "-"
+		if outcome.Err != nil {
+			// Keep the table readable with multi-line errors.
+			msg = strings.Join(strings.Fields(outcome.Err.Error()), " ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", outcome.Target, outcome.Node, outcome.Result, msg)
+	}
+	return w.Flush()
+}
+
+// runTargets runs fn on all nodes of targets using pool, waits until all
+// nodes are done, and returns the outcome of every node, ordered by target
+// and node. Nodes not started before ctx was done are skipped.
+func runTargets(
+	ctx context.Context,
+	pool *nodePool,
+	progress *progressReporter,
+	targets []*targetCluster,
+	fn func(*targetCluster, string) error,
+) []NodeOutcome {
+	var outcomes []NodeOutcome
+	index := map[string]int{}
+
+	for _, target := range targets {
+		for _, nodeName := range target.NodeNames {
+			index[target.Context+"/"+nodeName] = len(outcomes)
+			outcomes = append(outcomes, NodeOutcome{Target: target.Context, Node: nodeName})
+		}
+	}
+
+	pool.Run(ctx, targets, progress, func(target *targetCluster, nodeName string) {
+		outcome := &outcomes[index[target.Context+"/"+nodeName]]
+		if err := ctx.Err(); err != nil {
+			outcome.Result = ResultSkipped
+			outcome.Err = err
+			return
+		}
+		if err := fn(target, nodeName); err != nil {
+			outcome.Result = ResultFailed
+			outcome.Err = err
+			return
+		}
+		outcome.Result = ResultDone
+	})
+
+	return outcomes
+}
+
+// collectErrors waits for count errors, and returns all errors joined, or
+// nil if all succeeded.
+func collectErrors(results <-chan error, count int) error {
+	var errs []error
+	for i := 0; i < count; i++ {
+		if err := <-results; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
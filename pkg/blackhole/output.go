@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+)
+
+type OutputFormat string
+
+const (
+	OutputYAML  = OutputFormat("yaml")
+	OutputJSON  = OutputFormat("json")
+	OutputTable = OutputFormat("table")
+	OutputWide  = OutputFormat("wide")
+)
+
+// ParseOutputFormat returns the output format named name.
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	switch format := OutputFormat(name); format {
+	case OutputYAML, OutputJSON, OutputTable, OutputWide:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q", name)
+	}
+}
+
+// ShowOutput is the output of the show command.
+type ShowOutput struct {
+	Status ShowStatus `json:"status"`
+}
+
+type ShowStatus struct {
+	Cluster string         `json:"cluster"`
+	Targets []TargetOutput `json:"targets"`
+}
+
+// Is returns true if all nodes of all targets have the specified status.
+func (s *ShowStatus) Is(status BlackholeStatus) bool {
+	for _, target := range s.Targets {
+		for _, node := range target.Nodes {
+			if node.Status != status {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type TargetOutput struct {
+	Name  string       `json:"name"`
+	Valid bool         `json:"valid"`
+	Nodes []NodeOutput `json:"nodes"`
+}
+
+type NodeOutput struct {
+	Name       string                              `json:"name"`
+	Status     BlackholeStatus                     `json:"status"`
+	Categories map[AddressCategory]BlackholeStatus `json:"categories,omitempty"`
+	Network    NetworkStatus                       `json:"network"`
+	Addresses  int                                 `json:"addresses"`
+	Blocked    int                                 `json:"blocked"`
+	Foreign    []string                            `json:"foreign,omitempty"`
+
+	// Included only in detailed output.
+	Details *NodeDetails `json:"details,omitempty"`
+}
+
+// newShowOutput returns the output for the cluster status. Targets are
+// ordered by the targets order, and nodes are sorted by name. If details is
+// true, include the blocked and missing addresses on every node.
+func newShowOutput(cluster string, targets []string, status map[string]*clusterStatus, details bool) *ShowOutput {
+	out := &ShowOutput{
+		Status: ShowStatus{
+			Cluster: cluster,
+			Targets: []TargetOutput{},
+		},
+	}
+
+	for _, targetName := range targets {
+		targetStatus, ok := status[targetName]
+		if !ok {
+			continue
+		}
+
+		target := TargetOutput{
+			Name:  targetName,
+			Valid: targetStatus.Valid,
+			Nodes: []NodeOutput{},
+		}
+
+		for _, nodeName := range sortedKeys(targetStatus.Nodes) {
+			node := NodeOutput{
+				Name:       nodeName,
+				Status:     targetStatus.Nodes[nodeName],
+				Categories: targetStatus.Categories[nodeName],
+				Network:    targetStatus.Network[nodeName],
+				Addresses:  targetStatus.AddressCount,
+				Blocked:    targetStatus.BlockedCount[nodeName],
+				Foreign:    targetStatus.Foreign[nodeName],
+			}
+			if details {
+				node.Details = targetStatus.Details[nodeName]
+			}
+			target.Nodes = append(target.Nodes, node)
+		}
+
+		out.Status.Targets = append(out.Status.Targets, target)
+	}
+
+	return out
+}
+
+// Write writes the output in format.
+func (o *ShowOutput) Write(out io.Writer, format OutputFormat) error {
+	switch format {
+	case OutputJSON:
+		data, err := json.MarshalIndent(o, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", data)
+		return err
+	case OutputYAML:
+		data, err := yaml.Marshal(o)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	case OutputTable, OutputWide:
+		return o.writeTable(out, format == OutputWide)
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+func (o *ShowOutput) writeTable(out io.Writer, wide bool) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	header := []string{"TARGET", "NODE", "STATUS", "NETWORK"}
+	if wide {
+		header = append(header, "BLOCKED", "CATEGORIES", "FOREIGN", "MISSING")
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for _, target := range o.Status.Targets {
+		for _, node := range target.Nodes {
+			row := []string{target.Name, node.Name, string(node.Status), string(node.Network)}
+			if wide {
+				row = append(row,
+					fmt.Sprintf("%d/%d", node.Blocked, node.Addresses),
+					formatCategories(node.Categories),
+					fmt.Sprintf("%d", len(node.Foreign)),
+					formatMissing(node.Details))
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+	}
+
+	return w.Flush()
+}
+
+// formatCategories returns "api=blocked,nodes=unblocked,...".
+func formatCategories(categories map[AddressCategory]BlackholeStatus) string {
+	var res []string
+	for category, status := range categories {
+		res = append(res, fmt.Sprintf("%s=%s", category, status))
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+// formatMissing returns the missing addresses with their sources, like
+// "10.0.0.1(nodes:node-1)", or "-" if no address is missing.
+func formatMissing(details *NodeDetails) string {
+	if details == nil || len(details.Missing) == 0 {
+		return "-"
+	}
+	var res []string
+	for _, detail := range details.Missing {
+		var sources []string
+		for _, source := range detail.Sources {
+			sources = append(sources, fmt.Sprintf("%s:%s", source.Category, source.Name))
+		}
+		address := strings.ReplaceAll(detail.Address, elementSeparator, "/")
+		res = append(res, fmt.Sprintf("%s(%s)", address, strings.Join(sources, ",")))
+	}
+	return strings.Join(res, ",")
+}
+
+func sortedKeys(m map[string]BlackholeStatus) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func fakeShowOutput(t *testing.T, details bool) *ShowOutput {
+	executor := newFakeExecutor()
+	executor.AddRoutes("cluster2", "cluster2-2", fakeClusterAddresses[:2]...)
+	executor.AddForeignRoutes("hub", "hub-2", "10.0.0.1")
+	c := newFakeCommand(executor)
+
+	status, err := c.clusterStatus(context.TODO(), PortFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newShowOutput("blocked", []string{"hub", "cluster2"}, status, details)
+}
+
+func TestNewShowOutput(t *testing.T) {
+	out := fakeShowOutput(t, false)
+
+	var targets []string
+	for _, target := range out.Status.Targets {
+		targets = append(targets, target.Name)
+	}
+	if expected := []string{"hub", "cluster2"}; !reflect.DeepEqual(targets, expected) {
+		t.Errorf("expected targets %v, got %v", expected, targets)
+	}
+
+	node := out.Status.Targets[1].Nodes[1]
+	expected := NodeOutput{
+		Name:   "cluster2-2",
+		Status: StatusPartlyBlocked,
+		Categories: map[AddressCategory]BlackholeStatus{
+			CategoryAPI:    StatusBlocked,
+			CategoryNodes:  StatusPartlyBlocked,
+			CategoryRoutes: StatusUnblocked,
+		},
+		Network:   StatusNormal,
+		Addresses: len(fakeClusterAddresses),
+		Blocked:   2,
+	}
+	if !reflect.DeepEqual(node, expected) {
+		t.Errorf("expected node %+v, got %+v", expected, node)
+	}
+
+	if foreign := out.Status.Targets[0].Nodes[1].Foreign; !reflect.DeepEqual(foreign, []string{"10.0.0.1"}) {
+		t.Errorf("unexpected foreign blackholes %v", foreign)
+	}
+}
+
+func TestShowOutputDetails(t *testing.T) {
+	out := fakeShowOutput(t, true)
+
+	details := out.Status.Targets[1].Nodes[1].Details
+	if details == nil {
+		t.Fatal("no details")
+	}
+
+	expected := []AddressDetail{
+		{Address: "10.0.0.1", Sources: []AddressSource{{Category: CategoryNodes, Name: "blocked-1"}}},
+		{Address: "10.0.0.100", Sources: []AddressSource{{Category: CategoryAPI, Name: "10.0.0.100"}}},
+	}
+	if !reflect.DeepEqual(details.Blocked, expected) {
+		t.Errorf("expected blocked %+v, got %+v", expected, details.Blocked)
+	}
+
+	var missing []string
+	for _, detail := range details.Missing {
+		missing = append(missing, detail.Address)
+	}
+	if expected := []string{"10.0.0.2", "10.0.0.200", "10.0.0.3", "fd00::200"}; !reflect.DeepEqual(missing, expected) {
+		t.Errorf("expected missing %v, got %v", expected, missing)
+	}
+
+	// Details are not included unless requested.
+	if details := fakeShowOutput(t, false).Status.Targets[1].Nodes[1].Details; details != nil {
+		t.Errorf("unexpected details %+v", details)
+	}
+}
+
+func TestShowStatusIs(t *testing.T) {
+	status := &ShowStatus{
+		Targets: []TargetOutput{
+			{Name: "hub", Nodes: []NodeOutput{{Name: "hub-1", Status: StatusBlocked}}},
+			{Name: "cluster2", Nodes: []NodeOutput{{Name: "cluster2-1", Status: StatusBlocked}}},
+		},
+	}
+	if !status.Is(StatusBlocked) {
+		t.Error("status is not blocked")
+	}
+
+	status.Targets[1].Nodes[0].Status = StatusUnblocked
+	if status.Is(StatusBlocked) || status.Is(StatusUnblocked) {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestShowOutputStructured(t *testing.T) {
+	out := fakeShowOutput(t, true)
+
+	var buf bytes.Buffer
+	if err := out.Write(&buf, OutputJSON); err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON ShowOutput
+	if err := json.Unmarshal(buf.Bytes(), &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&fromJSON, out) {
+		t.Errorf("expected %+v, got %+v", out, fromJSON)
+	}
+
+	buf.Reset()
+	if err := out.Write(&buf, OutputYAML); err != nil {
+		t.Fatal(err)
+	}
+	var fromYAML ShowOutput
+	if err := yaml.Unmarshal(buf.Bytes(), &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&fromYAML, out) {
+		t.Errorf("expected %+v, got %+v", out, fromYAML)
+	}
+}
+
+func TestShowOutputTable(t *testing.T) {
+	out := fakeShowOutput(t, true)
+
+	var buf bytes.Buffer
+	if err := out.Write(&buf, OutputWide); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected header and 5 nodes, got:\n%s", buf.String())
+	}
+
+	fields := strings.Fields(lines[4])
+	expected := []string{
+		"cluster2",
+		"cluster2-2",
+		"partly-blocked",
+		"normal",
+		"2/6",
+		"api=blocked,nodes=partly-blocked,routes=unblocked",
+		"0",
+		"10.0.0.2(nodes:blocked-2),10.0.0.200(routes:default/console,routes:default/s3)," +
+			"10.0.0.3(nodes:blocked-3),fd00::200(routes:default/console)",
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected row %v, got %v", expected, fields)
+	}
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	if format, err := ParseOutputFormat("xml"); err == nil {
+		t.Errorf("parsing unknown format did not fail: %q", format)
+	}
+}
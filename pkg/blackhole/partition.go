@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Partition splits the clusters into isolated groups, blocking every cluster
+// of every group in all clusters of the other groups. The options targets are
+// ignored. If blocking a cluster fails, the blocks added by the partition are
+// rolled back, unless options.NoRollback is set, and then the results of the
+// clusters blocked before the failure are returned with the error.
+func Partition(ctx context.Context, groups [][]string, options *Options) ([]*Result, error) {
+	ctx = options.context(ctx)
+
+	if err := options.checkBlock(); err != nil {
+		return nil, err
+	}
+
+	commands, err := partitionCommands(groups, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return partition(ctx, commands, options.blockOptions())
+}
+
+// Heal removes the partition between the groups, unblocking every cluster of
+// every group in all clusters of the other groups. All clusters are unblocked
+// even if unblocking some of them fails.
+func Heal(ctx context.Context, groups [][]string, options *Options) ([]*Result, error) {
+	ctx = options.context(ctx)
+
+	if err := options.Filter.check(); err != nil {
+		return nil, err
+	}
+
+	commands, err := partitionCommands(groups, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return heal(ctx, commands, options.Filter)
+}
+
+// partition blocks the cluster of every command in the command targets. If
+// blocking a cluster fails without rollback, the results of the clusters
+// blocked before the failure are returned with the error. Otherwise the
+// clusters blocked before the failure are rolled back, keeping blocks that
+// existed before the partition, and the error reports the rolled back
+// clusters.
+func partition(ctx context.Context, commands []*command, options *blockOptions) ([]*Result, error) {
+	var results []*Result
+	var undos []blockUndo
+
+	for i, c := range commands {
+		dbglog(ctx).Printf("Blocking cluster %q in %d targets ...", c.Cluster.Context, len(c.Targets))
+		outcomes, undo, err := c.blockClusterWithUndo(ctx, options)
+		if err != nil {
+			if options.NoRollback {
+				return results, err
+			}
+			return nil, rollbackPartition(ctx, commands[:i], undos, err)
+		}
+		results = append(results, &Result{Cluster: c.Cluster.Context, Outcomes: outcomes})
+		undos = append(undos, undo)
+	}
+
+	return results, nil
+}
+
+// rollbackPartition undoes the blocks of the commands blocked before a
+// partition failed with err, and returns err with the rolled back clusters and
+// the rollback errors. The blocks are undone even if we were interrupted, so
+// we do not leave a partial partition.
+func rollbackPartition(ctx context.Context, commands []*command, undos []blockUndo, err error) error {
+	var names []string
+	var errs []error
+
+	for i, undo := range undos {
+		cluster := commands[i].Cluster.Context
+		dbglog(ctx).Printf("Rolling back cluster %q ...", cluster)
+		if undoErr := undo(ctx); undoErr != nil {
+			errs = append(errs, undoErr)
+			continue
+		}
+		names = append(names, cluster)
+	}
+
+	if len(names) > 0 {
+		err = fmt.Errorf("%w; rolled back clusters %s", err, strings.Join(names, ", "))
+	}
+
+	return errors.Join(append([]error{err}, errs...)...)
+}
+
+// heal unblocks the cluster of every command in the command targets, and
+// returns the results of the clusters unblocked successfully.
+func heal(ctx context.Context, commands []*command, filter PortFilter) ([]*Result, error) {
+	var results []*Result
+	var errs []error
+
+	for _, c := range commands {
+		dbglog(ctx).Printf("Unblocking cluster %q in %d targets ...", c.Cluster.Context, len(c.Targets))
+		outcomes, err := c.unblockCluster(ctx, filter)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		results = append(results, &Result{Cluster: c.Cluster.Context, Outcomes: outcomes})
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// partitionCommands returns a command for every cluster, with the clusters of
+// the other groups as targets.
+func partitionCommands(groups [][]string, options *Options) ([]*command, error) {
+	if err := validateGroups(groups); err != nil {
+		return nil, err
+	}
+
+	var commands []*command
+
+	for i, group := range groups {
+		var targets []string
+		for j, other := range groups {
+			if j != i {
+				targets = append(targets, other...)
+			}
+		}
+
+		groupOptions := *options
+		groupOptions.Targets = targets
+
+		for _, cluster := range group {
+			c, err := newCommand(cluster, &groupOptions)
+			if err != nil {
+				return nil, err
+			}
+			commands = append(commands, c)
+		}
+	}
+
+	return commands, nil
+}
+
+// validateGroups checks that there are at least 2 groups, and that every
+// cluster is in exactly one group.
+func validateGroups(groups [][]string) error {
+	if len(groups) < 2 {
+		return fmt.Errorf("partition requires at least 2 groups, got %d", len(groups))
+	}
+
+	seen := sets.New[string]()
+
+	for _, group := range groups {
+		if len(group) == 0 {
+			return fmt.Errorf("empty group in %v", groups)
+		}
+		for _, cluster := range group {
+			if seen.Has(cluster) {
+				return fmt.Errorf("cluster %q in multiple groups: %v", cluster, groups)
+			}
+			seen.Insert(cluster)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// newFakePartition returns commands partitioning the groups using a fake
+// executor. Cluster number N in the groups has one node "<name>-1" with
+// address 10.0.N.1, and API server address 10.0.N.100.
+func newFakePartition(executor nodeExecutor, groups [][]string) []*command {
+	index := map[string]int{}
+	for _, group := range groups {
+		for _, name := range group {
+			index[name] = len(index) + 1
+		}
+	}
+
+	var commands []*command
+
+	for i, group := range groups {
+		for _, name := range group {
+			n := index[name]
+			cluster := newFakeBlockedCluster(
+				name,
+				fmt.Sprintf("https://10.0.%d.100:6443", n),
+				map[string]string{name + "-1": fmt.Sprintf("10.0.%d.1", n)},
+				nil,
+			)
+
+			var targets []*targetCluster
+			for j, other := range groups {
+				if j == i {
+					continue
+				}
+				for _, target := range other {
+					targets = append(targets, newFakeTargetCluster(target, target+"-1"))
+				}
+			}
+
+			commands = append(commands, &command{
+				Cluster:  cluster,
+				Targets:  targets,
+				executor: executor,
+				pool:     newNodePool(0, 0),
+				progress: newProgressReporter("", 0, io.Discard),
+			})
+		}
+	}
+
+	return commands
+}
+
+func TestPartition(t *testing.T) {
+	executor := newFakeExecutor()
+	commands := newFakePartition(executor, [][]string{{"hub", "cluster1"}, {"cluster2"}})
+
+	results, err := partition(context.TODO(), commands, &blockOptions{Method: MethodRoute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Errorf("expected 3 results, got %d", len(results))
+	}
+
+	// Every cluster is blocked only in the clusters of the other group.
+	expected := map[string]sets.Set[string]{
+		"hub-1":      sets.New("10.0.3.1", "10.0.3.100"),
+		"cluster1-1": sets.New("10.0.3.1", "10.0.3.100"),
+		"cluster2-1": sets.New("10.0.1.1", "10.0.1.100", "10.0.2.1", "10.0.2.100"),
+	}
+	for _, target := range []string{"hub", "cluster1", "cluster2"} {
+		node := target + "-1"
+		if routes := executor.Routes(target, node); !routes.Equal(expected[node]) {
+			t.Errorf("expected %s routes %v, got %v", node, sets.List(expected[node]), sets.List(routes))
+		}
+	}
+
+	if _, err := heal(context.TODO(), commands, PortFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"hub", "cluster1", "cluster2"} {
+		if routes := executor.Routes(target, target+"-1"); routes.Len() != 0 {
+			t.Errorf("unexpected %s routes %v", target, sets.List(routes))
+		}
+	}
+}
+
+func TestPartitionFailure(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.Fail("hub", "hub-1", errors.New("oc debug failed"))
+	commands := newFakePartition(executor, [][]string{{"hub", "cluster1"}, {"cluster2"}})
+
+	// Blocking hub and cluster1 in cluster2 succeeds, blocking cluster2 in
+	// hub fails.
+	_, err := partition(context.TODO(), commands, &blockOptions{Method: MethodRoute})
+	if err == nil {
+		t.Fatal("partition with failing node did not fail")
+	}
+
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) || blockErr.Cluster != "cluster2" {
+		t.Fatalf("unexpected error %T: %s", err, err)
+	}
+
+	// The error reports the clusters rolled back.
+	if !strings.HasSuffix(err.Error(), "; rolled back clusters hub, cluster1") {
+		t.Errorf("rolled back clusters not reported: %s", err)
+	}
+
+	// The partition was rolled back.
+	for _, target := range []string{"cluster1", "cluster2"} {
+		if routes := executor.Routes(target, target+"-1"); routes.Len() != 0 {
+			t.Errorf("unexpected %s routes %v", target, sets.List(routes))
+		}
+	}
+}
+
+func TestPartitionFailureKeepsPreviousBlocks(t *testing.T) {
+	executor := newFakeExecutor()
+	commands := newFakePartition(executor, [][]string{{"hub", "cluster1"}, {"cluster2"}})
+
+	// Someone blocked hub in cluster2 before the partition.
+	if err := commands[0].BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	executor.Fail("hub", "hub-1", errors.New("oc debug failed"))
+
+	if _, err := partition(context.TODO(), commands, &blockOptions{Method: MethodRoute}); err == nil {
+		t.Fatal("partition with failing node did not fail")
+	}
+
+	// Only the blocks added by the partition were rolled back.
+	expected := sets.New("10.0.1.1", "10.0.1.100")
+	if routes := executor.Routes("cluster2", "cluster2-1"); !routes.Equal(expected) {
+		t.Errorf("expected cluster2 routes %v, got %v", sets.List(expected), sets.List(routes))
+	}
+
+	for _, c := range commands[:2] {
+		record, err := readRecord(context.TODO(), c.Targets[0], c.Cluster.Context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blocked := record != nil; blocked != (c.Cluster.Context == "hub") {
+			t.Errorf("unexpected cluster %q record %+v", c.Cluster.Context, record)
+		}
+	}
+}
+
+func TestPartitionFailureNoRollback(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.Fail("hub", "hub-1", errors.New("oc debug failed"))
+	commands := newFakePartition(executor, [][]string{{"hub", "cluster1"}, {"cluster2"}})
+
+	options := &blockOptions{Method: MethodRoute, NoRollback: true}
+	results, err := partition(context.TODO(), commands, options)
+	if err == nil {
+		t.Fatal("partition with failing node did not fail")
+	}
+
+	// The clusters blocked before the failure are reported.
+	if len(results) != 2 || results[0].Cluster != "hub" || results[1].Cluster != "cluster1" {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	expected := sets.New("10.0.1.1", "10.0.1.100", "10.0.2.1", "10.0.2.100")
+	if routes := executor.Routes("cluster2", "cluster2-1"); !routes.Equal(expected) {
+		t.Errorf("expected cluster2 routes %v, got %v", sets.List(expected), sets.List(routes))
+	}
+}
+
+func TestHealFailure(t *testing.T) {
+	executor := newFakeExecutor()
+	commands := newFakePartition(executor, [][]string{{"hub", "cluster1"}, {"cluster2"}})
+
+	if _, err := partition(context.TODO(), commands, &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	executor.Fail("hub", "hub-1", errors.New("oc debug failed"))
+
+	results, err := heal(context.TODO(), commands, PortFilter{})
+	if err == nil {
+		t.Fatal("heal with failing node did not fail")
+	}
+
+	var nodesErr *NodesError
+	if !errors.As(err, &nodesErr) {
+		t.Fatalf("unexpected error %T: %s", err, err)
+	}
+
+	// Other clusters are unblocked.
+	if len(results) != 2 {
+		t.Errorf("expected 2 results, got %d", len(results))
+	}
+	if routes := executor.Routes("cluster2", "cluster2-1"); routes.Len() != 0 {
+		t.Errorf("unexpected cluster2 routes %v", sets.List(routes))
+	}
+}
+
+func TestValidateGroups(t *testing.T) {
+	valid := [][]string{{"hub", "cluster1"}, {"cluster2"}}
+	if err := validateGroups(valid); err != nil {
+		t.Errorf("valid groups %v failed: %s", valid, err)
+	}
+
+	invalid := map[string][][]string{
+		"no groups":     nil,
+		"one group":     {{"hub", "cluster1"}},
+		"empty group":   {{"hub"}, {}},
+		"shared member": {{"hub", "cluster1"}, {"cluster1", "cluster2"}},
+		"duplicate":     {{"hub", "hub"}, {"cluster2"}},
+	}
+	for name, groups := range invalid {
+		if err := validateGroups(groups); err == nil {
+			t.Errorf("%s: %v did not fail", name, groups)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	// Debug pods are created in the default namespace, which allows privileged
+	// pods on OpenShift.
+	debugNamespace = "default"
+
+	debugContainer = "debug"
+
+	// How long we wait until a debug pod is running.
+	debugPodTimeout = 2 * time.Minute
+)
+
+// podExecutor runs scripts in a privileged debug pod created using the target
+// cluster client. Unlike ocDebugExecutor it does not require the `oc` command.
+type podExecutor struct {
+	targets map[string]*targetCluster
+}
+
+func newPodExecutor(targets []*targetCluster) *podExecutor {
+	e := &podExecutor{targets: map[string]*targetCluster{}}
+	for _, target := range targets {
+		e.targets[target.Context] = target
+	}
+	return e
+}
+
+func (e *podExecutor) Run(ctx context.Context, context string, nodeName string, script string) ([]byte, error) {
+	target, ok := e.targets[context]
+	if !ok {
+		return nil, fmt.Errorf("unknown target cluster %q", context)
+	}
+
+	pods := target.k8sClient.CoreV1().Pods(debugNamespace)
+
+	pod, err := pods.Create(ctx, debugPod(nodeName), metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	dbglog(ctx).Printf("Created debug pod %s on node %s", pod.Name, nodeName)
+
+	defer deleteDebugPod(ctx, target, pod.Name)
+
+	err = waitForPodRunning(ctx, target.k8sClient, debugNamespace, pod.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	command := []string{"chroot", "/host", "sh", "-c", script}
+	dbglog(ctx).Printf("Running command on node %s: %s", nodeName, command)
+
+	return execInPod(ctx, target.k8sClient, target.restConfig, debugNamespace,
+		pod.Name, debugContainer, command)
+}
+
+func deleteDebugPod(ctx context.Context, target *targetCluster, name string) {
+	// Using a context without cancellation since the context used to create
+	// the pod may be cancelled.
+	pods := target.k8sClient.CoreV1().Pods(debugNamespace)
+	err := pods.Delete(context.WithoutCancel(ctx), name, *metav1.NewDeleteOptions(0))
+	if err != nil {
+		errlog(ctx).Printf("cannot delete debug pod %q on cluster %q: %s",
+			name, target.Context, err)
+	}
+}
+
+// debugPod returns a privileged pod with access to the node network and root
+// filesystem, similar to the pod created by `oc debug node/name`.
+func debugPod(nodeName string) *apiv1.Pod {
+	spec := privilegedPodSpec([]string{"sleep", "3600"})
+	spec.NodeName = nodeName
+	spec.RestartPolicy = apiv1.RestartPolicyNever
+
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "oc-blackhole-debug-",
+			Namespace:    debugNamespace,
+			Labels:       map[string]string{"app": "oc-blackhole-debug"},
+		},
+		Spec: spec,
+	}
+}
+
+// privilegedPodSpec returns a spec for running command in a privileged
+// container using the host network, with the host root filesystem mounted at
+// /host.
+func privilegedPodSpec(command []string) apiv1.PodSpec {
+	privileged := true
+	root := int64(0)
+	grace := int64(0)
+
+	return apiv1.PodSpec{
+		HostNetwork:                   true,
+		HostPID:                       true,
+		TerminationGracePeriodSeconds: &grace,
+		// Run also on tainted nodes like control plane nodes.
+		Tolerations: []apiv1.Toleration{
+			{Operator: apiv1.TolerationOpExists},
+		},
+		Containers: []apiv1.Container{
+			{
+				Name:    debugContainer,
+				Image:   debugImage,
+				Command: command,
+				SecurityContext: &apiv1.SecurityContext{
+					Privileged: &privileged,
+					RunAsUser:  &root,
+				},
+				VolumeMounts: []apiv1.VolumeMount{
+					{Name: "host", MountPath: "/host"},
+				},
+			},
+		},
+		Volumes: []apiv1.Volume{
+			{
+				Name: "host",
+				VolumeSource: apiv1.VolumeSource{
+					HostPath: &apiv1.HostPathVolumeSource{Path: "/"},
+				},
+			},
+		},
+	}
+}
+
+func waitForPodRunning(ctx context.Context, client kubernetes.Interface, namespace string, name string) error {
+	return wait.PollUntilContextTimeout(ctx, time.Second, debugPodTimeout, true,
+		func(ctx context.Context) (bool, error) {
+			pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+
+			switch pod.Status.Phase {
+			case apiv1.PodRunning:
+				return true, nil
+			case apiv1.PodFailed, apiv1.PodSucceeded:
+				return false, fmt.Errorf("pod %q terminated: %s", name, pod.Status.Phase)
+			default:
+				return false, nil
+			}
+		})
+}
+
+// execInPod runs command in a pod container using the exec API and returns
+// the command output.
+func execInPod(
+	ctx context.Context,
+	client kubernetes.Interface,
+	config *rest.Config,
+	namespace string,
+	name string,
+	container string,
+	command []string,
+) ([]byte, error) {
+	req := client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(name).
+		SubResource("exec").
+		VersionedParams(&apiv1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exec in pod %q failed: %s: %s", name, err, stderr.Bytes())
+	}
+
+	return stdout.Bytes(), nil
+}
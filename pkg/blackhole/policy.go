@@ -0,0 +1,305 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// A BlackholePolicy declares a block on a hub cluster. The controller running
+// on the hub blocks the cluster in the target clusters, and reports the
+// status of every target node in the policy status. The policy is accessed
+// using the dynamic client, so we don't need generated clients.
+const (
+	policyGroup     = "blackhole.oc-blackhole.io"
+	policyVersion   = "v1alpha1"
+	policyResource  = "blackholepolicies"
+	policyKind      = "BlackholePolicy"
+	policyFinalizer = policyGroup + "/unblock"
+)
+
+var policyGVR = schema.GroupVersionResource{
+	Group:    policyGroup,
+	Version:  policyVersion,
+	Resource: policyResource,
+}
+
+var crdGVR = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// BlackholePolicy declares that a cluster is blocked in target clusters.
+type BlackholePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PolicySpec   `json:"spec"`
+	Status PolicyStatus `json:"status,omitempty"`
+}
+
+type PolicySpec struct {
+	// The blocked cluster kubeconfig context.
+	Cluster string `json:"cluster"`
+
+	// The target clusters kubeconfig contexts.
+	Targets []string `json:"targets"`
+
+	// How to block the cluster addresses (default route).
+	Method BlockMethod `json:"method,omitempty"`
+
+	// Block only these address categories. If empty, block all categories.
+	Only []AddressCategory `json:"only,omitempty"`
+
+	// Block only this protocol and ports. Requires a nft method.
+	Protocol string `json:"protocol,omitempty"`
+	Ports    []uint `json:"ports,omitempty"`
+
+	// When to block the cluster. If not set, the cluster is blocked when the
+	// policy is created.
+	Start *metav1.Time `json:"start,omitempty"`
+
+	// Unblock the cluster after duration since start. If not set, the
+	// cluster is blocked until the policy is deleted.
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
+type PolicyPhase string
+
+const (
+	// Waiting for the start time.
+	PhasePending = PolicyPhase("Pending")
+
+	// The cluster is blocked.
+	PhaseBlocked = PolicyPhase("Blocked")
+
+	// The duration has passed, and the cluster was unblocked.
+	PhaseExpired = PolicyPhase("Expired")
+
+	// Applying the policy failed; the controller will retry.
+	PhaseFailed = PolicyPhase("Failed")
+)
+
+// The policy condition type, true when the cluster is blocked in all target
+// nodes.
+const conditionBlocked = "Blocked"
+
+type PolicyStatus struct {
+	ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+	Phase              PolicyPhase `json:"phase,omitempty"`
+
+	// When the cluster will be unblocked.
+	Expires *metav1.Time `json:"expires,omitempty"`
+
+	// The blocked cluster and targets, so we can unblock them when the spec
+	// changes or the policy is deleted.
+	Applied *AppliedPolicy `json:"applied,omitempty"`
+
+	// Status of every target node.
+	Targets []TargetOutput `json:"targets,omitempty"`
+
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+type AppliedPolicy struct {
+	Cluster    string            `json:"cluster"`
+	Targets    []string          `json:"targets"`
+	Method     BlockMethod       `json:"method,omitempty"`
+	Categories []AddressCategory `json:"categories,omitempty"`
+	Protocol   string            `json:"protocol,omitempty"`
+	Ports      []uint            `json:"ports,omitempty"`
+}
+
+// filter returns the port filter of the policy.
+func (s *PolicySpec) filter() (PortFilter, error) {
+	return ParsePortFilter(s.Protocol, s.Ports)
+}
+
+// method returns the policy block method.
+func (s *PolicySpec) method() (BlockMethod, error) {
+	if s.Method == "" {
+		return MethodRoute, nil
+	}
+	return ParseBlockMethod(string(s.Method))
+}
+
+// categories returns the policy address categories.
+func (s *PolicySpec) categories() ([]AddressCategory, error) {
+	var names []string
+	for _, category := range s.Only {
+		names = append(names, string(category))
+	}
+	return ParseCategories(names)
+}
+
+// schedule returns the policy start time, and the expiry time, or zero time
+// if the policy does not expire.
+func (p *BlackholePolicy) schedule() (time.Time, time.Time) {
+	start := p.CreationTimestamp.Time
+	if p.Spec.Start != nil {
+		start = p.Spec.Start.Time
+	}
+	var expires time.Time
+	if p.Spec.Duration != nil {
+		expires = start.Add(p.Spec.Duration.Duration)
+	}
+	return start, expires
+}
+
+// hasFinalizer returns true if the policy has our finalizer.
+func (p *BlackholePolicy) hasFinalizer() bool {
+	for _, finalizer := range p.Finalizers {
+		if finalizer == policyFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// removeFinalizer removes our finalizer from the policy.
+func (p *BlackholePolicy) removeFinalizer() {
+	var res []string
+	for _, finalizer := range p.Finalizers {
+		if finalizer != policyFinalizer {
+			res = append(res, finalizer)
+		}
+	}
+	p.Finalizers = res
+}
+
+func policyFromUnstructured(u *unstructured.Unstructured) (*BlackholePolicy, error) {
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return nil, err
+	}
+	policy := &BlackholePolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("invalid policy %q: %s", u.GetName(), err)
+	}
+	return policy, nil
+}
+
+func policyToUnstructured(policy *BlackholePolicy) (*unstructured.Unstructured, error) {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{}
+	if err := json.Unmarshal(data, &u.Object); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// The BlackholePolicy custom resource definition, installed by
+// `oc blackhole controller install`.
+const policyCRD = `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: blackholepolicies.blackhole.oc-blackhole.io
+spec:
+  group: blackhole.oc-blackhole.io
+  scope: Namespaced
+  names:
+    kind: BlackholePolicy
+    listKind: BlackholePolicyList
+    plural: blackholepolicies
+    singular: blackholepolicy
+  versions:
+  - name: v1alpha1
+    served: true
+    storage: true
+    subresources:
+      status: {}
+    additionalPrinterColumns:
+    - name: Cluster
+      type: string
+      jsonPath: .spec.cluster
+    - name: Phase
+      type: string
+      jsonPath: .status.phase
+    - name: Expires
+      type: date
+      jsonPath: .status.expires
+    - name: Age
+      type: date
+      jsonPath: .metadata.creationTimestamp
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            required: [cluster, targets]
+            properties:
+              cluster:
+                type: string
+              targets:
+                type: array
+                minItems: 1
+                items:
+                  type: string
+              method:
+                type: string
+                enum: [route, nft-drop, nft-reject]
+              only:
+                type: array
+                items:
+                  type: string
+                  enum: [api, nodes, routes]
+              protocol:
+                type: string
+                enum: [tcp, udp]
+              ports:
+                type: array
+                items:
+                  type: integer
+                  minimum: 1
+                  maximum: 65535
+              start:
+                type: string
+                format: date-time
+              duration:
+                type: string
+          status:
+            type: object
+            x-kubernetes-preserve-unknown-fields: true
+`
+
+// InstallPolicyCRD creates or updates the BlackholePolicy custom resource
+// definition.
+func InstallPolicyCRD(ctx context.Context, client dynamic.Interface) error {
+	crd := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(policyCRD), &crd.Object); err != nil {
+		return err
+	}
+
+	crds := client.Resource(crdGVR)
+
+	dbglog(ctx).Printf("Creating custom resource definition %q", crd.GetName())
+
+	_, err := crds.Create(ctx, crd, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		var current *unstructured.Unstructured
+		current, err = crds.Get(ctx, crd.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		crd.SetResourceVersion(current.GetResourceVersion())
+		_, err = crds.Update(ctx, crd, metav1.UpdateOptions{})
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"sync"
+)
+
+// nodePool runs operations on target nodes, limiting the number of
+// concurrent operations globally and per target. Every node operation starts
+// a debug pod, so running all nodes at once on large clusters floods the API
+// server and the scheduler.
+type nodePool struct {
+	global    chan struct{}
+	perTarget int
+
+	mutex   sync.Mutex
+	targets map[string]chan struct{}
+}
+
+// newNodePool returns a pool running up to limit nodes concurrently, and up
+// to perTarget nodes of the same target. Zero means no limit.
+func newNodePool(limit int, perTarget int) *nodePool {
+	p := &nodePool{
+		perTarget: perTarget,
+		targets:   map[string]chan struct{}{},
+	}
+	if limit > 0 {
+		p.global = make(chan struct{}, limit)
+	}
+	return p
+}
+
+// Run runs fn on all nodes of targets, and waits until all nodes are done.
+// Nodes waiting for a free slot are reported as queued in progress. If ctx is
+// done while a node is queued, fn is called without waiting, and is expected
+// to fail quickly.
+func (p *nodePool) Run(ctx context.Context, targets []*targetCluster, progress *progressReporter, fn func(*targetCluster, string)) {
+	var wg sync.WaitGroup
+
+	for i := range targets {
+		target := targets[i]
+		for j := range target.NodeNames {
+			nodeName := target.NodeNames[j]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release := p.acquire(ctx, target.Context)
+				defer release()
+				progress.Start(1)
+				fn(target, nodeName)
+				progress.Add(1)
+			}()
+		}
+	}
+
+	wg.Wait()
+}
+
+// acquire waits until there is a free slot for a node of target, or ctx is
+// done, and returns a function releasing the slot. The target slot is
+// acquired first, so nodes waiting for their target do not hold global slots
+// needed by other targets.
+func (p *nodePool) acquire(ctx context.Context, target string) func() {
+	var acquired []chan struct{}
+	release := func() {
+		for _, slots := range acquired {
+			<-slots
+		}
+	}
+
+	for _, slots := range []chan struct{}{p.targetSlots(target), p.global} {
+		if slots == nil {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+			acquired = append(acquired, slots)
+		case <-ctx.Done():
+			return release
+		}
+	}
+
+	return release
+}
+
+// targetSlots returns the slots of target, or nil if there is no per target
+// limit.
+func (p *nodePool) targetSlots(target string) chan struct{} {
+	if p.perTarget <= 0 {
+		return nil
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	slots, ok := p.targets[target]
+	if !ok {
+		slots = make(chan struct{}, p.perTarget)
+		p.targets[target] = slots
+	}
+	return slots
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrency tracks the maximum number of concurrent calls, globally and
+// per target.
+type concurrency struct {
+	mutex     sync.Mutex
+	running   int
+	max       int
+	targets   map[string]int
+	maxTarget map[string]int
+	calls     int
+}
+
+func newConcurrency() *concurrency {
+	return &concurrency{targets: map[string]int{}, maxTarget: map[string]int{}}
+}
+
+func (c *concurrency) run(target *targetCluster, nodeName string) {
+	c.mutex.Lock()
+	c.calls++
+	c.running++
+	c.max = max(c.max, c.running)
+	c.targets[target.Context]++
+	c.maxTarget[target.Context] = max(c.maxTarget[target.Context], c.targets[target.Context])
+	c.mutex.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	c.mutex.Lock()
+	c.running--
+	c.targets[target.Context]--
+	c.mutex.Unlock()
+}
+
+func fakePoolTargets(t *testing.T) []*targetCluster {
+	targets := []*targetCluster{
+		newFakeTargetCluster("hub", "hub-1", "hub-2", "hub-3", "hub-4"),
+		newFakeTargetCluster("cluster2", "cluster2-1", "cluster2-2", "cluster2-3"),
+	}
+	for _, target := range targets {
+		if err := target.Inspect(context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return targets
+}
+
+func TestNodePoolLimit(t *testing.T) {
+	c := newConcurrency()
+	progress := newProgressReporter("testing", 7, io.Discard)
+
+	newNodePool(2, 0).Run(context.TODO(), fakePoolTargets(t), progress, c.run)
+
+	if c.calls != 7 {
+		t.Errorf("expected 7 calls, got %d", c.calls)
+	}
+	if c.max > 2 {
+		t.Errorf("expected up to 2 concurrent nodes, got %d", c.max)
+	}
+	if progress.done != 7 || progress.running != 0 {
+		t.Errorf("expected 7 done and 0 running, got %d done and %d running", progress.done, progress.running)
+	}
+}
+
+func TestNodePoolPerTarget(t *testing.T) {
+	c := newConcurrency()
+	progress := newProgressReporter("testing", 7, io.Discard)
+
+	newNodePool(0, 1).Run(context.TODO(), fakePoolTargets(t), progress, c.run)
+
+	if c.calls != 7 {
+		t.Errorf("expected 7 calls, got %d", c.calls)
+	}
+	for target, n := range c.maxTarget {
+		if n > 1 {
+			t.Errorf("expected up to 1 concurrent node in target %q, got %d", target, n)
+		}
+	}
+	if c.max > 2 {
+		t.Errorf("expected up to 2 concurrent nodes, got %d", c.max)
+	}
+}
+
+func TestNodePoolUnlimited(t *testing.T) {
+	c := newConcurrency()
+	progress := newProgressReporter("testing", 7, io.Discard)
+
+	newNodePool(0, 0).Run(context.TODO(), fakePoolTargets(t), progress, c.run)
+
+	if c.calls != 7 {
+		t.Errorf("expected 7 calls, got %d", c.calls)
+	}
+}
+
+func TestNodePoolCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := newNodePool(1, 0)
+	progress := newProgressReporter("testing", 7, io.Discard)
+
+	var mutex sync.Mutex
+	var calls int
+
+	// The first node holds the only slot until the context is cancelled;
+	// queued nodes must not wait for it.
+	pool.Run(ctx, fakePoolTargets(t), progress, func(target *targetCluster, nodeName string) {
+		mutex.Lock()
+		calls++
+		first := calls == 1
+		mutex.Unlock()
+		if first {
+			cancel()
+			<-ctx.Done()
+		}
+	})
+
+	if calls != 7 {
+		t.Errorf("expected 7 calls, got %d", calls)
+	}
+}
+
+func TestProgressQueued(t *testing.T) {
+	var out bytes.Buffer
+	progress := newProgressReporter("modifying nodes", 0, &out)
+	progress.SetTasks(5)
+	progress.Start(2)
+	progress.Add(1)
+
+	lines := strings.Split(out.String(), "\r")
+	last := lines[len(lines)-2]
+	if !strings.Contains(last, "modifying nodes (3 queued, 1 running, 1 done)") {
+		t.Errorf("unexpected progress %q", last)
+	}
+
+	// Setting the tasks starts a new phase.
+	progress.SetTasks(2)
+	if progress.running != 0 || progress.done != 0 {
+		t.Errorf("expected reset progress, got %d running and %d done", progress.running, progress.done)
+	}
+}
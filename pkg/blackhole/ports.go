@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// nft concatenation elements separator, like "10.0.0.1 . tcp . 6443".
+const elementSeparator = " . "
+
+// PortFilter limits a block to some protocols and ports. The zero value
+// matches all traffic.
+type PortFilter struct {
+	// "tcp" or "udp". If empty and Ports is not empty, match both protocols.
+	Protocol string
+
+	// If empty, match all ports.
+	Ports []uint16
+}
+
+// ParsePortFilter returns a filter for protocol and ports flags.
+func ParsePortFilter(protocol string, ports []uint) (PortFilter, error) {
+	var filter PortFilter
+
+	switch protocol {
+	case "", ProtocolTCP, ProtocolUDP:
+		filter.Protocol = protocol
+	default:
+		return filter, fmt.Errorf("unsupported protocol %q", protocol)
+	}
+
+	for _, port := range ports {
+		if port == 0 || port > 65535 {
+			return filter, fmt.Errorf("invalid port %d", port)
+		}
+		filter.Ports = append(filter.Ports, uint16(port))
+	}
+
+	return filter, nil
+}
+
+// check returns an error if the filter protocol or ports are invalid, for
+// filters not created by ParsePortFilter.
+func (f PortFilter) check() error {
+	switch f.Protocol {
+	case "", ProtocolTCP, ProtocolUDP:
+	default:
+		return fmt.Errorf("unsupported protocol %q", f.Protocol)
+	}
+	for _, port := range f.Ports {
+		if port == 0 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+	return nil
+}
+
+// IsEmpty returns true if the filter matches all traffic.
+func (f PortFilter) IsEmpty() bool {
+	return f.Protocol == "" && len(f.Ports) == 0
+}
+
+func (f PortFilter) String() string {
+	if f.IsEmpty() {
+		return "all"
+	}
+	protocols := f.protocols()
+	if len(f.Ports) == 0 {
+		return strings.Join(protocols, ",")
+	}
+	var ports []string
+	for _, port := range f.Ports {
+		ports = append(ports, fmt.Sprintf("%d", port))
+	}
+	return strings.Join(protocols, ",") + "/" + strings.Join(ports, ",")
+}
+
+// Elements returns the nft set elements matching the filter for addresses:
+// the address, "address . protocol", or "address . protocol . port".
+func (f PortFilter) Elements(addresses []string) []string {
+	if f.IsEmpty() {
+		return addresses
+	}
+
+	var res []string
+	for _, address := range addresses {
+		for _, protocol := range f.protocols() {
+			if len(f.Ports) == 0 {
+				res = append(res, address+elementSeparator+protocol)
+				continue
+			}
+			for _, port := range f.Ports {
+				res = append(res, fmt.Sprintf("%s%s%s%s%d",
+					address, elementSeparator, protocol, elementSeparator, port))
+			}
+		}
+	}
+	return res
+}
+
+func (f PortFilter) protocols() []string {
+	if f.Protocol != "" {
+		return []string{f.Protocol}
+	}
+	return []string{ProtocolTCP, ProtocolUDP}
+}
+
+// elementAddress returns the address of a set element.
+func elementAddress(element string) string {
+	return strings.SplitN(element, elementSeparator, 2)[0]
+}
+
+// elementBlocked returns true if element is blocked by blocked elements. The
+// element is blocked if it is in blocked, or if a wider element, such as the
+// element address, is blocked.
+func elementBlocked(element string, blocked sets.Set[string]) bool {
+	parts := strings.Split(element, elementSeparator)
+	for i := len(parts); i > 0; i-- {
+		if blocked.Has(strings.Join(parts[:i], elementSeparator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestParsePortFilter(t *testing.T) {
+	filter, err := ParsePortFilter("tcp", []uint{6443, 443})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := PortFilter{Protocol: ProtocolTCP, Ports: []uint16{6443, 443}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("expected %+v, got %+v", expected, filter)
+	}
+}
+
+func TestParsePortFilterInvalid(t *testing.T) {
+	cases := []struct {
+		protocol string
+		ports    []uint
+	}{
+		{"sctp", nil},
+		{"", []uint{0}},
+		{"tcp", []uint{65536}},
+	}
+
+	for _, c := range cases {
+		if filter, err := ParsePortFilter(c.protocol, c.ports); err == nil {
+			t.Errorf("parsing %q %v did not fail: %+v", c.protocol, c.ports, filter)
+		}
+	}
+}
+
+func TestPortFilterElements(t *testing.T) {
+	addresses := []string{"10.0.0.1", "fd00::1"}
+
+	cases := []struct {
+		filter   PortFilter
+		expected []string
+	}{
+		{
+			filter:   PortFilter{},
+			expected: addresses,
+		},
+		{
+			filter:   PortFilter{Protocol: ProtocolUDP},
+			expected: []string{"10.0.0.1 . udp", "fd00::1 . udp"},
+		},
+		{
+			filter: PortFilter{Protocol: ProtocolTCP, Ports: []uint16{6443, 443}},
+			expected: []string{
+				"10.0.0.1 . tcp . 6443",
+				"10.0.0.1 . tcp . 443",
+				"fd00::1 . tcp . 6443",
+				"fd00::1 . tcp . 443",
+			},
+		},
+		{
+			filter: PortFilter{Ports: []uint16{53}},
+			expected: []string{
+				"10.0.0.1 . tcp . 53",
+				"10.0.0.1 . udp . 53",
+				"fd00::1 . tcp . 53",
+				"fd00::1 . udp . 53",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		if elements := c.filter.Elements(addresses); !reflect.DeepEqual(elements, c.expected) {
+			t.Errorf("filter %s: expected %v, got %v", c.filter, c.expected, elements)
+		}
+	}
+}
+
+func TestElementBlocked(t *testing.T) {
+	blocked := sets.New("10.0.0.1", "10.0.0.2 . tcp", "10.0.0.3 . tcp . 6443")
+
+	cases := map[string]bool{
+		"10.0.0.1":              true,
+		"10.0.0.1 . tcp . 6443": true,
+		"10.0.0.2":              false,
+		"10.0.0.2 . tcp":        true,
+		"10.0.0.2 . tcp . 443":  true,
+		"10.0.0.2 . udp . 53":   false,
+		"10.0.0.3 . tcp . 6443": true,
+		"10.0.0.3 . tcp . 443":  false,
+		"10.0.0.4":              false,
+	}
+
+	for element, expected := range cases {
+		if res := elementBlocked(element, blocked); res != expected {
+			t.Errorf("element %q: expected %v, got %v", element, expected, res)
+		}
+	}
+}
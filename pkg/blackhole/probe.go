@@ -0,0 +1,279 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// A blackhole route or nft element does not prove that the cluster is
+// unreachable; traffic may go through a proxy, or a host name may resolve to
+// another address. Verifying a block runs probes from the target nodes to the
+// cluster addresses.
+//
+// The functions are defined in every script using them. The probes report
+// "kind address port host result" lines, using "-" for an empty host.
+const probeFunctions = `
+oc_blackhole_probe_tcp() {
+    if timeout 5 bash -c ": </dev/tcp/$1/$2" 2>/dev/null; then
+        echo "tcp $1 $2 - reachable"
+    else
+        echo "tcp $1 $2 - unreachable"
+    fi
+}
+
+oc_blackhole_probe_https() {
+    case "$1" in
+        *:*) addr="[$1]" ;;
+        *) addr=$1 ;;
+    esac
+    if curl --silent --insecure --output /dev/null --connect-timeout 5 --max-time 10 \
+            --resolve "$3:$2:$addr" "https://$3:$2/"; then
+        echo "https $1 $2 $3 reachable"
+    else
+        echo "https $1 $2 $3 unreachable"
+    fi
+}
+`
+
+type ProbeKind string
+
+const (
+	// Connect to the address port.
+	ProbeTCP = ProbeKind("tcp")
+
+	// Send a https request to the host, resolved to the address.
+	ProbeHTTPS = ProbeKind("https")
+)
+
+// The port used to probe the routes.
+const routePort = 443
+
+// Probe describes a connectivity check to a cluster address.
+type Probe struct {
+	Kind     ProbeKind       `json:"kind"`
+	Category AddressCategory `json:"category"`
+	Address  string          `json:"address"`
+	Port     uint16          `json:"port"`
+
+	// The host name for https probes.
+	Host string `json:"host,omitempty"`
+}
+
+// key returns the probe identity in the probe script output.
+func (p Probe) key() string {
+	host := p.Host
+	if host == "" {
+		host = "-"
+	}
+	return fmt.Sprintf("%s %s %d %s", p.Kind, p.Address, p.Port, host)
+}
+
+// element returns the nft set element matching the probe traffic.
+func (p Probe) element() string {
+	return fmt.Sprintf("%s%s%s%s%d", p.Address, elementSeparator, ProtocolTCP, elementSeparator, p.Port)
+}
+
+func (p Probe) String() string {
+	if p.Host != "" {
+		return fmt.Sprintf("%s://%s:%d", p.Kind, p.Host, p.Port)
+	}
+	return fmt.Sprintf("%s/%d", p.Kind, p.Port)
+}
+
+// Probes returns the probes for the cluster addresses in categories matching
+// filter: tcp connect to the API server port, and https request to a route
+// host for every route address. Node addresses are not probed, since nodes do
+// not have a port we can expect to be open.
+func (c *blockedCluster) Probes(categories []AddressCategory, filter PortFilter) []Probe {
+	var res []Probe
+
+	for _, category := range categories {
+		switch category {
+		case CategoryAPI:
+			for _, address := range c.APIServerAddresses {
+				res = append(res, Probe{
+					Kind:     ProbeTCP,
+					Category: CategoryAPI,
+					Address:  address,
+					Port:     c.APIServerPort,
+				})
+			}
+		case CategoryRoutes:
+			for _, address := range sets.List(sets.New(c.RouteAddresses...)) {
+				hosts := append([]string(nil), c.RouteHosts[address]...)
+				if len(hosts) == 0 {
+					continue
+				}
+				sort.Strings(hosts)
+				res = append(res, Probe{
+					Kind:     ProbeHTTPS,
+					Category: CategoryRoutes,
+					Address:  address,
+					Port:     routePort,
+					Host:     hosts[0],
+				})
+			}
+		}
+	}
+
+	if filter.IsEmpty() {
+		return res
+	}
+
+	// Probe only traffic matching the filter; other traffic is not expected
+	// to be blocked.
+	var filtered []Probe
+	for _, probe := range res {
+		if elementBlocked(probe.element(), sets.New(filter.Elements([]string{probe.Address})...)) {
+			filtered = append(filtered, probe)
+		}
+	}
+	return filtered
+}
+
+// probeScript returns a script running probes.
+func probeScript(probes []Probe) string {
+	var sb strings.Builder
+	sb.WriteString(probeFunctions)
+	for _, probe := range probes {
+		switch probe.Kind {
+		case ProbeTCP:
+			fmt.Fprintf(&sb, "oc_blackhole_probe_tcp %s %d\n", probe.Address, probe.Port)
+		case ProbeHTTPS:
+			fmt.Fprintf(&sb, "oc_blackhole_probe_https %s %d %s\n", probe.Address, probe.Port, probe.Host)
+		}
+	}
+	return sb.String()
+}
+
+// probeNode runs probes on the node, and returns the reachable probes.
+func probeNode(
+	ctx context.Context,
+	executor nodeExecutor,
+	context string,
+	nodeName string,
+	probes []Probe,
+) (sets.Set[string], error) {
+	dbglog(ctx).Printf("probing %d addresses from node %s", len(probes), nodeName)
+
+	out, err := executor.Run(ctx, context, nodeName, probeScript(probes))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseProbeResults(out)
+}
+
+// parseProbeResults returns the keys of the reachable probes.
+func parseProbeResults(out []byte) (sets.Set[string], error) {
+	reachable := sets.New[string]()
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Should never happen, so fail loudly.
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("invalid probe result %q", scanner.Text())
+		}
+
+		key := strings.Join(fields[:4], " ")
+		switch fields[4] {
+		case "reachable":
+			reachable.Insert(key)
+		case "unreachable":
+		default:
+			return nil, fmt.Errorf("invalid probe result %q", scanner.Text())
+		}
+	}
+
+	return reachable, scanner.Err()
+}
+
+// ProbeResult is the result of a probe from a target node.
+type ProbeResult struct {
+	Target    string `json:"target"`
+	Node      string `json:"node"`
+	Probe     Probe  `json:"probe"`
+	Reachable bool   `json:"reachable"`
+}
+
+// Verification describes the connectivity to the cluster from the target
+// nodes.
+type Verification struct {
+	// The expected status, blocked or unblocked.
+	Expected BlackholeStatus `json:"expected"`
+
+	// Results sorted by target, node, and probe.
+	Results []ProbeResult `json:"results"`
+}
+
+// Failed returns the results not matching the expected status.
+func (v *Verification) Failed() []ProbeResult {
+	var res []ProbeResult
+	for _, result := range v.Results {
+		if result.Reachable != (v.Expected == StatusUnblocked) {
+			res = append(res, result)
+		}
+	}
+	return res
+}
+
+// Err returns an error if some results do not match the expected status.
+func (v *Verification) Err() error {
+	failed := v.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	state := "reachable"
+	if v.Expected == StatusUnblocked {
+		state = "unreachable"
+	}
+	return fmt.Errorf("cluster is not %s: %d of %d probes are %s",
+		v.Expected, len(failed), len(v.Results), state)
+}
+
+// Write writes the results as a table.
+func (v *Verification) Write(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tNODE\tCATEGORY\tADDRESS\tPROBE\tRESULT")
+	for _, result := range v.Results {
+		state := "unreachable"
+		if result.Reachable {
+			state = "reachable"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			result.Target, result.Node, result.Probe.Category, result.Probe.Address,
+			result.Probe, state)
+	}
+	return w.Flush()
+}
+
+// sortResults sorts results by target, node, and probe.
+func sortResults(results []ProbeResult) {
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		return a.Probe.key() < b.Probe.key()
+	})
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestProbes(t *testing.T) {
+	cluster := &blockedCluster{
+		APIServerAddresses: []string{"10.0.0.100"},
+		APIServerPort:      6443,
+		NodeAddresses:      []string{"10.0.0.1"},
+		RouteAddresses:     []string{"10.0.0.200", "fd00::200"},
+		RouteHosts: map[string][]string{
+			"10.0.0.200": {"s3.apps.example.com", "console.apps.example.com"},
+			"fd00::200":  {"console.apps.example.com"},
+		},
+	}
+
+	expected := []Probe{
+		{Kind: ProbeTCP, Category: CategoryAPI, Address: "10.0.0.100", Port: 6443},
+		{Kind: ProbeHTTPS, Category: CategoryRoutes, Address: "10.0.0.200", Port: 443, Host: "console.apps.example.com"},
+		{Kind: ProbeHTTPS, Category: CategoryRoutes, Address: "fd00::200", Port: 443, Host: "console.apps.example.com"},
+	}
+
+	probes := cluster.Probes(AllCategories, PortFilter{})
+	if !reflect.DeepEqual(probes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, probes)
+	}
+
+	// Only probes matching the filter.
+	probes = cluster.Probes(AllCategories, PortFilter{Ports: []uint16{443}})
+	if !reflect.DeepEqual(probes, expected[1:]) {
+		t.Errorf("expected %+v, got %+v", expected[1:], probes)
+	}
+
+	probes = cluster.Probes(AllCategories, PortFilter{Protocol: ProtocolUDP})
+	if len(probes) != 0 {
+		t.Errorf("unexpected probes %+v", probes)
+	}
+
+	probes = cluster.Probes([]AddressCategory{CategoryNodes}, PortFilter{})
+	if len(probes) != 0 {
+		t.Errorf("unexpected probes %+v", probes)
+	}
+}
+
+func TestProbeScript(t *testing.T) {
+	script := probeScript([]Probe{
+		{Kind: ProbeTCP, Category: CategoryAPI, Address: "10.0.0.100", Port: 6443},
+		{Kind: ProbeHTTPS, Category: CategoryRoutes, Address: "fd00::200", Port: 443, Host: "console.apps.example.com"},
+	})
+
+	expected := probeFunctions +
+		"oc_blackhole_probe_tcp 10.0.0.100 6443\n" +
+		"oc_blackhole_probe_https fd00::200 443 console.apps.example.com\n"
+
+	if script != expected {
+		t.Errorf("expected script %q, got %q", expected, script)
+	}
+}
+
+func TestParseProbeResults(t *testing.T) {
+	out := []byte("tcp 10.0.0.100 6443 - unreachable\n" +
+		"https fd00::200 443 console.apps.example.com reachable\n")
+
+	reachable, err := parseProbeResults(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := sets.New("https fd00::200 443 console.apps.example.com")
+	if !reachable.Equal(expected) {
+		t.Errorf("expected %v, got %v", sets.List(expected), sets.List(reachable))
+	}
+}
+
+func TestParseProbeResultsInvalid(t *testing.T) {
+	for _, out := range []string{
+		"tcp 10.0.0.100 6443 unreachable\n",
+		"tcp 10.0.0.100 6443 - maybe\n",
+	} {
+		if _, err := parseProbeResults([]byte(out)); err == nil {
+			t.Errorf("parsing %q did not fail", out)
+		}
+	}
+}
+
+func TestVerificationWrite(t *testing.T) {
+	v := &Verification{
+		Expected: StatusBlocked,
+		Results: []ProbeResult{
+			{
+				Target: "hub",
+				Node:   "hub-1",
+				Probe:  Probe{Kind: ProbeTCP, Category: CategoryAPI, Address: "10.0.0.100", Port: 6443},
+			},
+			{
+				Target:    "hub",
+				Node:      "hub-1",
+				Probe:     Probe{Kind: ProbeHTTPS, Category: CategoryRoutes, Address: "10.0.0.200", Port: 443, Host: "console.apps.example.com"},
+				Reachable: true,
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := v.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"TARGET  NODE   CATEGORY  ADDRESS     PROBE                                 RESULT",
+		"hub     hub-1  api       10.0.0.100  tcp/6443                              unreachable",
+		"hub     hub-1  routes    10.0.0.200  https://console.apps.example.com:443  reachable",
+		"",
+	}, "\n")
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	if err := v.Err(); err == nil || !strings.Contains(err.Error(), "1 of 2 probes are reachable") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+type progressReporter struct {
+	mutex       sync.Mutex
+	description string
+	tasks       uint
+	running     uint
+	done        uint
+	width       int
+	out         io.Writer
+	notify      func(ProgressUpdate)
+}
+
+// ProgressUpdate describes the progress of an operation.
+type ProgressUpdate struct {
+	Description string
+
+	// Number of tasks, zero if not known yet.
+	Tasks uint
+
+	Queued  uint
+	Running uint
+	Done    uint
+}
+
+// newProgressReporter return a new progress indicator.
+func newProgressReporter(descripiton string, tasks uint, out io.Writer) *progressReporter {
+	p := &progressReporter{
+		description: descripiton,
+		tasks:       tasks,
+		width:       80,
+		out:         out,
+	}
+	p.update()
+	return p
+}
+
+// SetTasks changes the number of tasks, and resets the running and completed
+// tasks.
+func (p *progressReporter) SetTasks(tasks uint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.tasks = tasks
+	p.running = 0
+	p.done = 0
+	p.update()
+}
+
+// SetDescription changes the description.
+func (p *progressReporter) SetDescription(desciption string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.description != desciption {
+		p.description = desciption
+		p.update()
+	}
+}
+
+// Start marks queued tasks as running.
+func (p *progressReporter) Start(started uint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.running+p.done < p.tasks {
+		p.running = min(p.running+started, p.tasks-p.done)
+		p.update()
+	}
+}
+
+// Add completed tasks to progress.
+func (p *progressReporter) Add(completed uint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.done < p.tasks {
+		p.done = min(p.done+completed, p.tasks)
+		p.running -= min(p.running, completed)
+		p.update()
+	}
+}
+
+// Notify calls fn with the current progress, and when the progress changes.
+func (p *progressReporter) Notify(fn func(ProgressUpdate)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.notify = fn
+	if fn != nil {
+		fn(p.current())
+	}
+}
+
+// Clear the progress output.
+func (p *progressReporter) Clear() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	line := strings.Repeat(" ", p.width-1) + "\r"
+	p.out.Write([]byte(line))
+}
+
+func (p *progressReporter) current() ProgressUpdate {
+	update := ProgressUpdate{
+		Description: p.description,
+		Tasks:       p.tasks,
+		Running:     p.running,
+		Done:        p.done,
+	}
+	if p.tasks > 0 {
+		update.Queued = p.tasks - p.running - p.done
+	}
+	return update
+}
+
+func (p *progressReporter) update() {
+	current := p.current()
+	if p.notify != nil {
+		p.notify(current)
+	}
+
+	var line string
+	if current.Tasks == 0 {
+		line = fmt.Sprintf("[ ---- ] %s", current.Description)
+	} else {
+		value := float64(current.Done*100) / float64(current.Tasks)
+		line = fmt.Sprintf("[ %3.0f%% ] %s (%d queued, %d running, %d done)",
+			value, current.Description, current.Queued, current.Running, current.Done)
+	}
+
+	// Padd output to full line to cover previous line data
+	padding := strings.Repeat(" ", max(p.width-1-len(line), 0))
+
+	p.out.Write([]byte(line + padding + "\r"))
+}
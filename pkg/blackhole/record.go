@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// When blocking a cluster, we keep a record of the blocked addresses in a
+// config map in every target cluster. Unblocking uses the record, so we can
+// unblock the cluster when the blocked cluster addresses have changed, or
+// when the blocked cluster is not reachable.
+//
+// When a block expires, the target nodes unblock the cluster, but cannot
+// delete the record. The record keeps the expiry time, and expired records
+// are ignored by readers until the next block replaces them, or cleanup
+// deletes them.
+const (
+	recordNamespace = "default"
+	recordPrefix    = "oc-blackhole-"
+	recordKey       = "record.json"
+)
+
+var recordLabels = map[string]string{"app.kubernetes.io/managed-by": "oc-blackhole"}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// blockRecord describes the addresses blocked in a target cluster.
+type blockRecord struct {
+	// The blocked cluster context.
+	Cluster string `json:"cluster"`
+
+	// The method used for the last block.
+	Method BlockMethod `json:"method"`
+
+	// The blocked addresses per category.
+	Addresses map[AddressCategory][]string `json:"addresses"`
+
+	// When the cluster was blocked.
+	Time time.Time `json:"time"`
+
+	// Who blocked the cluster.
+	User string `json:"user"`
+
+	// When the target nodes unblock the cluster, if the block expires.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// The protocol marking our blackhole routes. Records written by older
+	// versions have no route protocol, and their routes are not marked.
+	RouteProto string `json:"routeProto,omitempty"`
+}
+
+// Add adds addresses to the record.
+func (r *blockRecord) Add(addresses map[AddressCategory][]string) {
+	if r.Addresses == nil {
+		r.Addresses = map[AddressCategory][]string{}
+	}
+	for category, list := range addresses {
+		merged := sets.New(r.Addresses[category]...)
+		merged.Insert(list...)
+		r.Addresses[category] = sets.List(merged)
+	}
+}
+
+// Set replaces the addresses of the categories in addresses.
+func (r *blockRecord) Set(addresses map[AddressCategory][]string) {
+	if r.Addresses == nil {
+		r.Addresses = map[AddressCategory][]string{}
+	}
+	for category, list := range addresses {
+		r.Addresses[category] = sets.List(sets.New(list...))
+	}
+}
+
+// Remove removes the addresses of categories from the record.
+func (r *blockRecord) Remove(categories []AddressCategory) {
+	for _, category := range categories {
+		delete(r.Addresses, category)
+	}
+}
+
+// IsEmpty returns true if the record has no addresses.
+func (r *blockRecord) IsEmpty() bool {
+	return len(r.Addresses) == 0
+}
+
+// expired returns true if the target nodes have unblocked the cluster.
+func (r *blockRecord) expired() bool {
+	return r.Expires != nil && time.Now().After(*r.Expires)
+}
+
+// unmarkedRoutes returns true if the record was written by an older version
+// adding unmarked blackhole routes.
+func (r *blockRecord) unmarkedRoutes() bool {
+	return r.RouteProto == ""
+}
+
+// CategoryAddresses returns sorted list of unique recorded addresses in
+// categories.
+func (r *blockRecord) CategoryAddresses(categories []AddressCategory) []string {
+	res := sets.New[string]()
+	for _, category := range categories {
+		res.Insert(r.Addresses[category]...)
+	}
+	return sets.List(res)
+}
+
+// recordName returns the name of the record config map for the blocked
+// cluster. Context names may contain characters not allowed in names, so we
+// add a hash of the context to keep the name unique.
+func recordName(blockedContext string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(blockedContext), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	hash := sha256.Sum256([]byte(blockedContext))
+	return fmt.Sprintf("%s%s-%x", recordPrefix, name, hash[:4])
+}
+
+// readRecord returns the record of the blocked cluster in target, or nil if
+// there is no record or the record has expired.
+func readRecord(ctx context.Context, target *targetCluster, blockedContext string) (*blockRecord, error) {
+	configMaps := target.k8sClient.CoreV1().ConfigMaps(recordNamespace)
+
+	cm, err := configMaps.Get(ctx, recordName(blockedContext), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := &blockRecord{}
+	if err := json.Unmarshal([]byte(cm.Data[recordKey]), record); err != nil {
+		return nil, fmt.Errorf("invalid record %q in cluster %q: %s", cm.Name, target.Context, err)
+	}
+
+	if record.expired() {
+		dbglog(ctx).Printf("Ignoring expired record %q in cluster %q", cm.Name, target.Context)
+		return nil, nil
+	}
+
+	return record, nil
+}
+
+// readAllRecords returns the records of all blocked clusters in target,
+// ignoring expired records.
+func readAllRecords(ctx context.Context, target *targetCluster) (map[string]*blockRecord, error) {
+	list, err := target.k8sClient.CoreV1().ConfigMaps(recordNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: recordLabels}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]*blockRecord{}
+	for _, cm := range list.Items {
+		record := &blockRecord{}
+		if err := json.Unmarshal([]byte(cm.Data[recordKey]), record); err != nil {
+			return nil, fmt.Errorf("invalid record %q in cluster %q: %s", cm.Name, target.Context, err)
+		}
+		if record.expired() {
+			continue
+		}
+		res[record.Cluster] = record
+	}
+
+	return res, nil
+}
+
+// writeRecord creates or updates the record in target.
+func writeRecord(ctx context.Context, target *targetCluster, record *blockRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	cm := &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      recordName(record.Cluster),
+			Namespace: recordNamespace,
+			Labels:    recordLabels,
+		},
+		Data: map[string]string{recordKey: string(data)},
+	}
+
+	dbglog(ctx).Printf("Writing record %q in cluster %q", cm.Name, target.Context)
+
+	configMaps := target.k8sClient.CoreV1().ConfigMaps(recordNamespace)
+
+	_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// deleteRecord deletes the record of the blocked cluster in target. Deleting
+// a missing record is not an error.
+func deleteRecord(ctx context.Context, target *targetCluster, blockedContext string) error {
+	name := recordName(blockedContext)
+	dbglog(ctx).Printf("Deleting record %q in cluster %q", name, target.Context)
+
+	err := target.k8sClient.CoreV1().ConfigMaps(recordNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// deleteAllRecords deletes the records of all blocked clusters in target.
+func deleteAllRecords(ctx context.Context, target *targetCluster) error {
+	configMaps := target.k8sClient.CoreV1().ConfigMaps(recordNamespace)
+
+	list, err := configMaps.List(ctx, metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: recordLabels}),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, cm := range list.Items {
+		dbglog(ctx).Printf("Deleting record %q in cluster %q", cm.Name, target.Context)
+		err := configMaps.Delete(ctx, cm.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// currentUser returns the user running the command, for recording who blocked
+// a cluster.
+func currentUser() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		return name + "@" + host
+	}
+
+	return name
+}
@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRecordName(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+	for _, blockedContext := range []string{
+		"cluster1",
+		"default/api-perf1-example-com:6443/kube:admin",
+		"Cluster_With_A_Very_Long_Name_That_Does_Not_Fit_In_A_Config_Map_Name",
+	} {
+		name := recordName(blockedContext)
+		if !valid.MatchString(name) || len(name) > 63 {
+			t.Errorf("invalid record name %q for context %q", name, blockedContext)
+		}
+	}
+
+	if recordName("a/b") == recordName("a:b") {
+		t.Error("different contexts have the same record name")
+	}
+}
+
+func TestBlockRecordAddRemove(t *testing.T) {
+	record := &blockRecord{Cluster: "blocked"}
+
+	record.Add(map[AddressCategory][]string{
+		CategoryNodes:  {"10.0.0.2", "10.0.0.1"},
+		CategoryRoutes: {"10.0.0.200"},
+	})
+	record.Add(map[AddressCategory][]string{
+		CategoryNodes: {"10.0.0.3", "10.0.0.1"},
+	})
+
+	expected := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	if addresses := record.CategoryAddresses([]AddressCategory{CategoryNodes}); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected addresses %v, got %v", expected, addresses)
+	}
+
+	record.Remove([]AddressCategory{CategoryNodes})
+	if record.IsEmpty() {
+		t.Fatal("record is empty after removing some categories")
+	}
+
+	record.Remove([]AddressCategory{CategoryRoutes})
+	if !record.IsEmpty() {
+		t.Errorf("record not empty after removing all categories: %+v", record)
+	}
+}
+
+func TestBlockRecordSet(t *testing.T) {
+	record := &blockRecord{Cluster: "blocked"}
+
+	record.Add(map[AddressCategory][]string{
+		CategoryNodes:  {"10.0.0.1", "10.0.0.2"},
+		CategoryRoutes: {"10.0.0.200"},
+	})
+	record.Set(map[AddressCategory][]string{
+		CategoryNodes: {"10.0.0.3", "10.0.0.1", "10.0.0.3"},
+	})
+
+	expected := map[AddressCategory][]string{
+		CategoryNodes:  {"10.0.0.1", "10.0.0.3"},
+		CategoryRoutes: {"10.0.0.200"},
+	}
+	if !reflect.DeepEqual(record.Addresses, expected) {
+		t.Errorf("expected addresses %v, got %v", expected, record.Addresses)
+	}
+}
+
+func TestWriteReadRecord(t *testing.T) {
+	target := newFakeTargetCluster("hub", "hub-1")
+
+	record, err := readRecord(context.TODO(), target, "blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record != nil {
+		t.Fatalf("unexpected record %+v", record)
+	}
+
+	record = &blockRecord{
+		Cluster:   "blocked",
+		Method:    MethodNftDrop,
+		Addresses: map[AddressCategory][]string{CategoryAPI: {"10.0.0.100"}},
+		User:      "user@host",
+	}
+
+	// Writing twice updates the record.
+	for i := 0; i < 2; i++ {
+		if err := writeRecord(context.TODO(), target, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read, err := readRecord(context.TODO(), target, "blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, record) {
+		t.Errorf("expected record %+v, got %+v", record, read)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := deleteRecord(context.TODO(), target, "blocked"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExpiredRecord(t *testing.T) {
+	target := newFakeTargetCluster("hub", "hub-1")
+
+	past := time.Now().Add(-time.Minute)
+	expired := &blockRecord{
+		Cluster:   "expired",
+		Method:    MethodRoute,
+		Addresses: map[AddressCategory][]string{CategoryAPI: {"10.0.0.100"}},
+		Expires:   &past,
+	}
+	future := time.Now().Add(time.Hour)
+	active := &blockRecord{
+		Cluster:   "active",
+		Method:    MethodRoute,
+		Addresses: map[AddressCategory][]string{CategoryAPI: {"10.0.1.100"}},
+		Expires:   &future,
+	}
+	for _, record := range []*blockRecord{expired, active} {
+		if err := writeRecord(context.TODO(), target, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The target nodes unblocked the cluster when the block expired.
+	if record, err := readRecord(context.TODO(), target, "expired"); err != nil || record != nil {
+		t.Errorf("expired record was not ignored: %+v, %v", record, err)
+	}
+	if record, err := readRecord(context.TODO(), target, "active"); err != nil || record == nil {
+		t.Errorf("active record was ignored: %v", err)
+	}
+
+	records, err := readAllRecords(context.TODO(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := records["expired"]; ok || len(records) != 1 {
+		t.Errorf("unexpected records %v", records)
+	}
+}
+
+func TestBlockRecordExpires(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	options := &blockOptions{Method: MethodRoute, Expire: 10 * time.Minute}
+	if err := c.BlockCluster(context.TODO(), options); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := readRecord(context.TODO(), c.Targets[0], "blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Expires == nil || time.Until(*record.Expires) < options.Expire {
+		t.Errorf("unexpected record expiry %v", record.Expires)
+	}
+
+	// Blocking without expiry keeps the record until unblocked.
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err = readRecord(context.TODO(), c.Targets[0], "blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Expires != nil {
+		t.Errorf("unexpected record expiry %v", record.Expires)
+	}
+}
@@ -0,0 +1,222 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Blocking a cluster is transactional: if blocking fails on some nodes, we
+// wait for all nodes, and undo the changes on the nodes that were blocked.
+
+// BlockError is returned when blocking a cluster failed on some nodes.
+type BlockError struct {
+	Cluster string
+
+	// Outcome of every target node, ordered by target and node.
+	Outcomes []NodeOutcome
+
+	// True if the changes were undone.
+	RolledBack bool
+}
+
+func (e *BlockError) Error() string {
+	counts := countResults(e.Outcomes)
+	failed := counts[ResultFailed] + counts[ResultRollbackFailed] + counts[ResultSkipped]
+
+	msg := fmt.Sprintf("blocking cluster %q failed on %d of %d nodes", e.Cluster, failed, len(e.Outcomes))
+	if e.RolledBack {
+		msg += fmt.Sprintf(", rolled back %d nodes", counts[ResultRolledBack])
+	} else {
+		msg += fmt.Sprintf(", %d nodes blocked", counts[ResultDone])
+	}
+	return fmt.Sprintf("%s: %s", msg, outcomeErrors(e.Outcomes))
+}
+
+// Write writes the outcome of every node as a table.
+func (e *BlockError) Write(out io.Writer) error {
+	return writeOutcomes(out, e.Outcomes)
+}
+
+// rollbackBlock undoes the changes on the blocked nodes using the node state
+// before blocking, and restores the previous records. Failed nodes are rolled
+// back if their state was inspected before the failure, since blocking may
+// have modified them. Records are kept in targets with nodes that may still
+// be blocked, so the cluster can be unblocked later.
+func (c *command) rollbackBlock(
+	ctx context.Context,
+	outcomes []NodeOutcome,
+	states map[string]*nodeState,
+	records map[string]*blockRecord,
+	options *blockOptions,
+	addresses []string,
+) error {
+	dbglog(ctx).Printf("Rolling back cluster %q block ...", c.Cluster.Context)
+
+	c.progress.SetDescription("rolling back")
+
+	unit := expiryUnit(c.Cluster.Context)
+
+	// Roll back even if the block was cancelled.
+	ctx = context.WithoutCancel(ctx)
+
+	index := map[string]int{}
+	for i, outcome := range outcomes {
+		index[outcome.Target+"/"+outcome.Node] = i
+	}
+
+	c.progress.SetTasks(uint(len(outcomes)))
+
+	c.pool.Run(ctx, c.Targets, c.progress, func(target *targetCluster, nodeName string) {
+		outcome := &outcomes[index[target.Context+"/"+nodeName]]
+		state, ok := states[outcome.Target+"/"+outcome.Node]
+		if !ok || (outcome.Result != ResultDone && outcome.Result != ResultFailed) {
+			return
+		}
+		script := rollbackBlockScript(state, options.Method, addresses, options.Filter, unit)
+		if _, err := c.executor.Run(ctx, outcome.Target, outcome.Node, script); err != nil {
+			outcome.Err = errors.Join(outcome.Err, err)
+			outcome.Result = ResultRollbackFailed
+			return
+		}
+		dbglog(ctx).Printf("Cluster %q rolled back in node %q", c.Cluster.Context, outcome.Node)
+		// A failed node is reported as failed, even if the partial changes
+		// were undone.
+		if outcome.Result == ResultDone {
+			outcome.Result = ResultRolledBack
+		}
+	})
+
+	// Nodes that failed before inspection, or failed to roll back, may be
+	// still blocked.
+	keep := sets.New[string]()
+	for _, outcome := range outcomes {
+		_, inspected := states[outcome.Target+"/"+outcome.Node]
+		if outcome.Result == ResultRollbackFailed || (outcome.Result == ResultFailed && !inspected) {
+			keep.Insert(outcome.Target)
+		}
+	}
+
+	return c.restoreRecords(ctx, records, keep)
+}
+
+// undoBlock undoes a successful block using the node states and the records
+// read before blocking. Blocks and records that existed before the block are
+// kept. If undoing fails on some nodes, the error is a *NodesError.
+func (c *command) undoBlock(
+	ctx context.Context,
+	blocked []NodeOutcome,
+	states map[string]*nodeState,
+	records map[string]*blockRecord,
+	options *blockOptions,
+	addresses []string,
+) error {
+	defer c.progress.Clear()
+
+	outcomes := slices.Clone(blocked)
+
+	if err := c.rollbackBlock(ctx, outcomes, states, records, options, addresses); err != nil {
+		return err
+	}
+
+	if countResults(outcomes)[ResultRollbackFailed] == 0 {
+		return nil
+	}
+
+	// Report the outcome of rolling back every node.
+	for i := range outcomes {
+		switch outcomes[i].Result {
+		case ResultRolledBack:
+			outcomes[i].Result = ResultDone
+		case ResultRollbackFailed:
+			outcomes[i].Result = ResultFailed
+		}
+	}
+
+	return &NodesError{
+		Operation: fmt.Sprintf("rolling back cluster %q", c.Cluster.Context),
+		Outcomes:  outcomes,
+	}
+}
+
+// restoreRecords writes back the records read before blocking, and deletes
+// records created by the block. The records in the keep targets are not
+// modified.
+func (c *command) restoreRecords(ctx context.Context, records map[string]*blockRecord, keep sets.Set[string]) error {
+	for _, target := range c.Targets {
+		if keep.Has(target.Context) {
+			dbglog(ctx).Printf("Keeping cluster %q record in target %q", c.Cluster.Context, target.Context)
+			continue
+		}
+		var err error
+		if record, ok := records[target.Context]; ok {
+			err = writeRecord(ctx, target, record)
+		} else {
+			err = deleteRecord(ctx, target, c.Cluster.Context)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackBlockScript returns a script undoing blockNode on a node, using the
+// node state before blocking. Routes and elements blocked before are kept,
+// and elements moved between the drop and reject sets are moved back. The
+// previous expiry cannot be restored, so the expiry is cancelled only if
+// nothing was blocked before.
+func rollbackBlockScript(state *nodeState, method BlockMethod, addresses []string, filter PortFilter, unit string) string {
+	var sb strings.Builder
+
+	elements := filter.Elements(addresses)
+
+	switch method {
+	case MethodRoute:
+		var added []string
+		for _, address := range addresses {
+			if !state.Routes.Has(address) {
+				added = append(added, address)
+			}
+		}
+		sb.WriteString(deleteRoutesScript(added))
+	case MethodNftDrop, MethodNftReject:
+		verdict, current, other, otherVerdict := nftDrop, state.Dropped, state.Rejected, nftReject
+		if method == MethodNftReject {
+			verdict, current, other, otherVerdict = nftReject, state.Rejected, state.Dropped, nftDrop
+		}
+		var added, moved []string
+		for _, element := range elements {
+			if !current.Has(element) {
+				added = append(added, element)
+			}
+			if other.Has(element) {
+				moved = append(moved, element)
+			}
+		}
+		sb.WriteString(deleteNftElementsScript(verdict, added))
+		sb.WriteString(addNftElementsScript(otherVerdict, moved))
+	}
+
+	blocked := state.Blocked()
+	blockedBefore := false
+	for _, element := range elements {
+		if elementBlocked(element, blocked) {
+			blockedBefore = true
+			break
+		}
+	}
+	if !blockedBefore {
+		sb.WriteString(cancelExpiryScript(unit))
+	}
+
+	return sb.String()
+}
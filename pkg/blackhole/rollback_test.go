@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestBlockErrorRolledBack(t *testing.T) {
+	e := &BlockError{
+		Cluster: "blocked",
+		Outcomes: []NodeOutcome{
+			{Target: "hub", Node: "hub-1", Result: ResultRolledBack},
+			{Target: "hub", Node: "hub-2", Result: ResultFailed, Err: errors.New("oc debug\nfailed")},
+			{Target: "hub", Node: "hub-3", Result: ResultRollbackFailed, Err: errors.New("timeout")},
+		},
+		RolledBack: true,
+	}
+
+	expected := `blocking cluster "blocked" failed on 2 of 3 nodes, rolled back 1 nodes: ` +
+		"hub/hub-2: oc debug\nfailed; hub/hub-3: timeout"
+	if e.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, e.Error())
+	}
+
+	var out bytes.Buffer
+	if err := e.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedTable := strings.Join([]string{
+		"TARGET  NODE   RESULT           ERROR",
+		"hub     hub-1  rolled-back      -",
+		"hub     hub-2  failed           oc debug failed",
+		"hub     hub-3  rollback-failed  timeout",
+		"",
+	}, "\n")
+	if out.String() != expectedTable {
+		t.Errorf("expected:\n%s\ngot:\n%s", expectedTable, out.String())
+	}
+}
+
+func TestBlockErrorNoRollback(t *testing.T) {
+	e := &BlockError{
+		Cluster: "blocked",
+		Outcomes: []NodeOutcome{
+			{Target: "hub", Node: "hub-1", Result: ResultDone},
+			{Target: "hub", Node: "hub-2", Result: ResultFailed, Err: errors.New("failed")},
+		},
+	}
+
+	expected := `blocking cluster "blocked" failed on 1 of 2 nodes, 1 nodes blocked: hub/hub-2: failed`
+	if e.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, e.Error())
+	}
+}
+
+func TestRollbackBlockScriptRoute(t *testing.T) {
+	state := &nodeState{
+		Routes:   sets.New("10.0.0.1"),
+		Dropped:  sets.New[string](),
+		Rejected: sets.New[string](),
+	}
+
+	script := rollbackBlockScript(state, MethodRoute, []string{"10.0.0.1", "10.0.0.2"}, PortFilter{}, "unit")
+
+	// The route blocked before is kept, and so is the expiry.
+	expected := deleteRoutesScript([]string{"10.0.0.2"})
+	if script != expected {
+		t.Errorf("expected %q, got %q", expected, script)
+	}
+}
+
+func TestRollbackBlockScriptNft(t *testing.T) {
+	state := &nodeState{
+		Routes:   sets.New[string](),
+		Dropped:  sets.New[string](),
+		Rejected: sets.New[string](),
+	}
+
+	filter := PortFilter{Protocol: ProtocolTCP, Ports: []uint16{6443}}
+	script := rollbackBlockScript(state, MethodNftReject, []string{"10.0.0.1"}, filter, "unit")
+
+	expected := deleteNftElementsScript(nftReject, []string{"10.0.0.1 . tcp . 6443"}) +
+		cancelExpiryScript("unit")
+	if script != expected {
+		t.Errorf("expected %q, got %q", expected, script)
+	}
+}
@@ -0,0 +1,329 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// StatusMatrix describes which clusters block which clusters.
+type StatusMatrix struct {
+	// The inspected contexts.
+	Contexts []string
+
+	// Blocks[target][cluster] is the status of cluster in target.
+	Blocks map[string]map[string]BlackholeStatus
+
+	// Addresses blocked in target which do not belong to any cluster.
+	Unknown map[string][]string
+
+	// Errors inspecting clusters. A cluster that could not be inspected as a
+	// target is missing in Blocks.
+	Errors map[string]error
+}
+
+// InspectStatus inspects all nodes of the options targets, and returns a
+// matrix of which clusters block which clusters. If the options have no
+// targets, all contexts are used. Clusters that cannot be loaded are reported
+// in the matrix errors.
+func InspectStatus(ctx context.Context, options *Options) (*StatusMatrix, error) {
+	ctx = options.context(ctx)
+
+	contexts := options.Targets
+	if len(contexts) == 0 {
+		var err error
+		contexts, err = options.contexts()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateContexts("", contexts); err != nil {
+		return nil, err
+	}
+
+	var clusters []*blockedCluster
+	var targets []*targetCluster
+	loadErrors := map[string]error{}
+
+	for _, name := range contexts {
+		configs, err := options.restConfigs([]string{name})
+		if err != nil {
+			loadErrors[name] = err
+			continue
+		}
+		cluster, err := loadBlockedCluster(name, configs[name])
+		if err != nil {
+			loadErrors[name] = err
+			continue
+		}
+		target, err := loadTargetCluster(name, configs[name])
+		if err != nil {
+			loadErrors[name] = err
+			continue
+		}
+		clusters = append(clusters, cluster)
+		targets = append(targets, target)
+	}
+
+	executor, err := newExecutor(options, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	matrix := inspectStatusMatrix(ctx, executor, options.pool(), clusters, targets)
+	matrix.Contexts = contexts
+	for name, err := range loadErrors {
+		matrix.Errors[name] = err
+	}
+
+	return matrix, nil
+}
+
+// inspectStatusMatrix inspects all nodes of targets, and maps the blocked
+// addresses to clusters. If a cluster cannot be inspected, we use the block
+// records in the targets to find its addresses.
+func inspectStatusMatrix(
+	ctx context.Context,
+	executor nodeExecutor,
+	pool *nodePool,
+	clusters []*blockedCluster,
+	targets []*targetCluster,
+) *StatusMatrix {
+	matrix := &StatusMatrix{
+		Blocks:  map[string]map[string]BlackholeStatus{},
+		Unknown: map[string][]string{},
+		Errors:  map[string]error{},
+	}
+
+	for _, cluster := range clusters {
+		matrix.Contexts = append(matrix.Contexts, cluster.Context)
+	}
+
+	// Inspect all clusters and targets concurrently.
+
+	type inspection struct {
+		context string
+		target  bool
+		err     error
+	}
+
+	inspections := make(chan inspection)
+
+	for i := range clusters {
+		cluster := clusters[i]
+		go func() {
+			dbglog(ctx).Printf("Inspecting cluster %q ...", cluster.Context)
+			inspections <- inspection{context: cluster.Context, err: cluster.Inspect(ctx)}
+		}()
+	}
+
+	for i := range targets {
+		target := targets[i]
+		go func() {
+			dbglog(ctx).Printf("Inspecting target %q ...", target.Context)
+			inspections <- inspection{context: target.Context, target: true, err: target.Inspect(ctx)}
+		}()
+	}
+
+	inspectedClusters := sets.New[string]()
+	inspectedTargets := sets.New[string]()
+
+	for i := 0; i < len(clusters)+len(targets); i++ {
+		result := <-inspections
+		if result.err != nil {
+			dbglog(ctx).Printf("Cannot inspect cluster %q: %s", result.context, result.err)
+			if _, ok := matrix.Errors[result.context]; !ok {
+				matrix.Errors[result.context] = result.err
+			}
+			continue
+		}
+		if result.target {
+			inspectedTargets.Insert(result.context)
+		} else {
+			inspectedClusters.Insert(result.context)
+		}
+	}
+
+	// Inspect all nodes of inspected targets concurrently.
+
+	var reachable []*targetCluster
+	for _, target := range targets {
+		if inspectedTargets.Has(target.Context) {
+			reachable = append(reachable, target)
+		}
+	}
+
+	count := 0
+	for _, target := range reachable {
+		count += len(target.NodeNames)
+	}
+
+	results := make(chan *nodeResult, count)
+
+	progress := newProgressReporter("", 0, io.Discard)
+	pool.Run(ctx, reachable, progress, func(target *targetCluster, nodeName string) {
+		state, err := inspectNode(ctx, executor, target.Context, nodeName)
+		results <- &nodeResult{Context: target.Context, Node: nodeName, State: state, Err: err}
+	})
+
+	// Addresses blocked on every node, per target.
+	blocked := map[string]map[string]sets.Set[string]{}
+	for _, target := range reachable {
+		blocked[target.Context] = map[string]sets.Set[string]{}
+	}
+
+	// Errors of failed nodes, per target and node.
+	nodeErrors := map[string]map[string]error{}
+
+	for i := 0; i < count; i++ {
+		result := <-results
+		if result.Err != nil {
+			if nodeErrors[result.Context] == nil {
+				nodeErrors[result.Context] = map[string]error{}
+			}
+			nodeErrors[result.Context][result.Node] = result.Err
+			continue
+		}
+		addresses := sets.New[string]()
+		for element := range result.State.Blocked() {
+			addresses.Insert(elementAddress(element))
+		}
+		blocked[result.Context][result.Node] = addresses
+	}
+
+	// Report all failed nodes of a target, ordered by node.
+	for _, target := range reachable {
+		var errs []error
+		for _, nodeName := range target.NodeNames {
+			if err, ok := nodeErrors[target.Context][nodeName]; ok {
+				errs = append(errs, fmt.Errorf("node %q: %w", nodeName, err))
+			}
+		}
+		if len(errs) > 0 {
+			matrix.Errors[target.Context] = errors.Join(errs...)
+		}
+	}
+
+	// Map blocked addresses to clusters.
+
+	for _, target := range reachable {
+		if _, ok := nodeErrors[target.Context]; ok {
+			continue
+		}
+
+		records, err := readAllRecords(ctx, target)
+		if err != nil {
+			matrix.Errors[target.Context] = err
+			continue
+		}
+
+		known := sets.New[string]()
+		blocks := map[string]BlackholeStatus{}
+
+		for _, cluster := range clusters {
+			var addresses []string
+			if inspectedClusters.Has(cluster.Context) {
+				addresses = cluster.AllAddresses()
+			} else if record, ok := records[cluster.Context]; ok {
+				addresses = record.CategoryAddresses(AllCategories)
+			} else {
+				continue
+			}
+
+			known.Insert(addresses...)
+
+			if cluster.Context == target.Context {
+				continue
+			}
+
+			blocks[cluster.Context] = nodesStatus(addresses, blocked[target.Context])
+		}
+
+		matrix.Blocks[target.Context] = blocks
+
+		unknown := sets.New[string]()
+		for _, addresses := range blocked[target.Context] {
+			unknown = unknown.Union(addresses.Difference(known))
+		}
+		if unknown.Len() > 0 {
+			matrix.Unknown[target.Context] = sets.List(unknown)
+		}
+	}
+
+	return matrix
+}
+
+// nodesStatus returns the status of addresses in all nodes. If the nodes
+// status is different, the status is partly blocked.
+func nodesStatus(addresses []string, nodes map[string]sets.Set[string]) BlackholeStatus {
+	var res BlackholeStatus
+	for _, blocked := range nodes {
+		status := blackholeStatus(addresses, blocked)
+		if res == "" {
+			res = status
+		} else if res != status {
+			return StatusPartlyBlocked
+		}
+	}
+	if res == "" {
+		return StatusUnblocked
+	}
+	return res
+}
+
+// Write writes the matrix as a table, with a row for every target and a
+// column for every blocked cluster, and the unknown blocked addresses. Use Err
+// to report the inspection errors.
+func (m *StatusMatrix) Write(out io.Writer) {
+	contexts := append([]string(nil), m.Contexts...)
+	sort.Strings(contexts)
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "TARGET\t%s\n", strings.Join(contexts, "\t"))
+
+	for _, target := range contexts {
+		blocks, ok := m.Blocks[target]
+		if !ok {
+			continue
+		}
+		row := []string{target}
+		for _, cluster := range contexts {
+			status, ok := blocks[cluster]
+			switch {
+			case cluster == target:
+				row = append(row, "-")
+			case !ok:
+				row = append(row, "?")
+			default:
+				row = append(row, string(status))
+			}
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+
+	for _, target := range sets.List(sets.KeySet(m.Unknown)) {
+		fmt.Fprintf(out, "\nunknown blocked addresses in %s: %s\n",
+			target, strings.Join(m.Unknown[target], ", "))
+	}
+}
+
+// Err returns the errors inspecting clusters, ordered by cluster name, or nil
+// if all clusters were inspected.
+func (m *StatusMatrix) Err() error {
+	var errs []error
+	for _, name := range sets.List(sets.KeySet(m.Errors)) {
+		errs = append(errs, fmt.Errorf("error inspecting %s: %w", name, m.Errors[name]))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newFakeStatusClusters returns the fake command clusters and targets, and
+// clusters for the fake command targets, so every cluster is both blocked
+// and target.
+func newFakeStatusClusters(c *command) ([]*blockedCluster, []*targetCluster) {
+	hub := newFakeBlockedCluster(
+		"hub",
+		"https://10.1.0.100:6443",
+		map[string]string{"hub-1": "10.1.0.1", "hub-2": "10.1.0.2"},
+		nil,
+	)
+	cluster2 := newFakeBlockedCluster(
+		"cluster2",
+		"https://10.2.0.100:6443",
+		map[string]string{"cluster2-1": "10.2.0.1"},
+		nil,
+	)
+
+	clusters := []*blockedCluster{c.Cluster, hub, cluster2}
+	targets := append([]*targetCluster{newFakeTargetCluster("blocked", "blocked-1")}, c.Targets...)
+
+	return clusters, targets
+}
+
+func TestInspectStatusMatrix(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodRoute}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Block hub on one node of cluster2 and an unknown address in hub.
+	executor.AddRoutes("cluster2", "cluster2-1", "10.1.0.1", "10.1.0.2", "10.1.0.100")
+	executor.AddRoutes("hub", "hub-1", "192.168.1.1")
+
+	clusters, targets := newFakeStatusClusters(c)
+	matrix := inspectStatusMatrix(context.TODO(), executor, newNodePool(0, 0), clusters, targets)
+
+	expected := map[string]map[string]BlackholeStatus{
+		"blocked": {
+			"hub":      StatusUnblocked,
+			"cluster2": StatusUnblocked,
+		},
+		"hub": {
+			"blocked":  StatusBlocked,
+			"cluster2": StatusUnblocked,
+		},
+		"cluster2": {
+			"blocked": StatusBlocked,
+			"hub":     StatusPartlyBlocked,
+		},
+	}
+	if !reflect.DeepEqual(matrix.Blocks, expected) {
+		t.Errorf("expected blocks %v, got %v", expected, matrix.Blocks)
+	}
+
+	expectedUnknown := map[string][]string{"hub": {"192.168.1.1"}}
+	if !reflect.DeepEqual(matrix.Unknown, expectedUnknown) {
+		t.Errorf("expected unknown %v, got %v", expectedUnknown, matrix.Unknown)
+	}
+
+	if len(matrix.Errors) != 0 {
+		t.Errorf("unexpected errors %v", matrix.Errors)
+	}
+
+	var out bytes.Buffer
+	matrix.Write(&out)
+	if !strings.Contains(out.String(), "unknown blocked addresses in hub: 192.168.1.1") {
+		t.Errorf("unknown addresses not reported:\n%s", out.String())
+	}
+}
+
+func TestInspectStatusMatrixFromRecords(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+
+	if err := c.BlockCluster(context.TODO(), &blockOptions{Method: MethodNftDrop}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The blocked cluster is not reachable, so its addresses are found in the
+	// records.
+	c.Cluster.server = "https://[invalid"
+
+	clusters, targets := newFakeStatusClusters(c)
+	matrix := inspectStatusMatrix(context.TODO(), executor, newNodePool(0, 0), clusters, targets[1:])
+
+	for _, target := range []string{"hub", "cluster2"} {
+		if status := matrix.Blocks[target]["blocked"]; status != StatusBlocked {
+			t.Errorf("expected %q blocked in %q, got %q", "blocked", target, status)
+		}
+		if unknown := matrix.Unknown[target]; len(unknown) != 0 {
+			t.Errorf("unexpected unknown addresses in %q: %v", target, unknown)
+		}
+	}
+
+	if _, ok := matrix.Errors["blocked"]; !ok {
+		t.Error("inspecting unreachable cluster did not fail")
+	}
+}
+
+func TestInspectStatusMatrixNodeErrors(t *testing.T) {
+	executor := newFakeExecutor()
+	executor.Fail("hub", "hub-1", errors.New("hub-1 failed"))
+	executor.Fail("hub", "hub-2", errors.New("hub-2 failed"))
+	c := newFakeCommand(executor)
+
+	clusters, targets := newFakeStatusClusters(c)
+	matrix := inspectStatusMatrix(context.TODO(), executor, newNodePool(0, 0), clusters, targets)
+
+	// All failed nodes are reported.
+	err, ok := matrix.Errors["hub"]
+	if !ok {
+		t.Fatal("inspecting failing nodes did not fail")
+	}
+	expected := "node \"hub-1\": hub-1 failed\nnode \"hub-2\": hub-2 failed"
+	if err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err)
+	}
+
+	if _, ok := matrix.Blocks["hub"]; ok {
+		t.Errorf("unexpected blocks for failed target: %v", matrix.Blocks["hub"])
+	}
+
+	// The errors are not mixed with the table.
+	var out bytes.Buffer
+	matrix.Write(&out)
+	if strings.Contains(out.String(), "failed") {
+		t.Errorf("errors written with the table:\n%s", out.String())
+	}
+	if err := matrix.Err(); err == nil || !strings.Contains(err.Error(), "error inspecting hub: "+expected) {
+		t.Errorf("unexpected matrix error %v", err)
+	}
+}
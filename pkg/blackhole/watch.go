@@ -0,0 +1,269 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	routeapi "github.com/openshift/api/route/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Blocking is a snapshot of the target nodes and the blocked cluster
+// addresses. When watching, we use informers on the nodes of both clusters
+// and on the blocked cluster routes, and reconcile the block when nodes or
+// routes are added or removed.
+
+// defaultWatchDelay is the time to wait after a change before reconciling,
+// so multiple changes, like adding several nodes, are handled together.
+const defaultWatchDelay = 5 * time.Second
+
+// defaultWatchRetry is the time to wait before retrying a failed reconcile.
+const defaultWatchRetry = 30 * time.Second
+
+// WatchCluster keeps the cluster blocked in all target nodes until ctx is
+// done. New target nodes, and new blocked cluster addresses are blocked, and
+// addresses no longer used by the blocked cluster are unblocked. The cluster
+// must be blocked before watching. The cluster is reconciled once when the
+// watch starts, since changes before the informers are synced are reported
+// as part of the initial list.
+func (c *command) WatchCluster(ctx context.Context, options *blockOptions) error {
+	// Reconciling must not extend the expiry time.
+	var expireAt time.Time
+	if options.Expire > 0 {
+		expireAt = time.Now().Add(options.Expire)
+	}
+
+	changes := make(chan struct{}, 1)
+	notify := func(reason string) {
+		dbglog(ctx).Printf("%s, reconciling cluster %q", reason, c.Cluster.Context)
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	var factories []informers.SharedInformerFactory
+	defer func() {
+		cancel()
+		for _, factory := range factories {
+			factory.Shutdown()
+		}
+	}()
+
+	var synced []cache.InformerSynced
+
+	factory := informers.NewSharedInformerFactory(c.Cluster.k8sClient, 0)
+	factories = append(factories, factory)
+	informer := factory.Core().V1().Nodes().Informer()
+	if _, err := informer.AddEventHandler(blockedNodesHandler(c.Cluster.Context, notify)); err != nil {
+		return err
+	}
+	synced = append(synced, informer.HasSynced)
+
+	for _, target := range c.Targets {
+		factory := informers.NewSharedInformerFactory(target.k8sClient, 0)
+		factories = append(factories, factory)
+		informer := factory.Core().V1().Nodes().Informer()
+		if _, err := informer.AddEventHandler(targetNodesHandler(target.Context, notify)); err != nil {
+			return err
+		}
+		synced = append(synced, informer.HasSynced)
+	}
+
+	routeInformer := cache.NewSharedIndexInformer(c.routesListWatch(ctx), &routeapi.Route{}, 0, cache.Indexers{})
+	if _, err := routeInformer.AddEventHandler(routesHandler(c.Cluster.Context, notify)); err != nil {
+		return err
+	}
+	synced = append(synced, routeInformer.HasSynced)
+
+	for _, factory := range factories {
+		factory.Start(ctx.Done())
+	}
+	go routeInformer.Run(ctx.Done())
+
+	dbglog(ctx).Printf("Watching cluster %q ...", c.Cluster.Context)
+
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return nil
+	}
+
+	notify("Watch started")
+
+	var timer <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			dbglog(ctx).Printf("Stopped watching cluster %q", c.Cluster.Context)
+			return nil
+		case <-changes:
+			if timer == nil {
+				timer = time.After(c.watchDelay)
+			}
+		case <-timer:
+			timer = nil
+			reconcileOptions := *options
+			if !expireAt.IsZero() {
+				// systemd-run requires a positive time.
+				reconcileOptions.Expire = max(time.Until(expireAt), time.Second)
+			}
+			if err := c.reconcile(ctx, &reconcileOptions); err != nil {
+				errlog(ctx).Printf("Cannot reconcile cluster %q: %s", c.Cluster.Context, err)
+				timer = time.After(c.watchRetry)
+			}
+		}
+	}
+}
+
+// reconcile blocks the current blocked cluster addresses in all current
+// target nodes, and unblocks recorded addresses no longer used by the blocked
+// cluster.
+func (c *command) reconcile(ctx context.Context, options *blockOptions) error {
+	defer c.progress.Clear()
+
+	c.progress.SetDescription("inspecting clusters")
+
+	if err := c.inspectClusters(ctx); err != nil {
+		return err
+	}
+
+	records, err := c.readRecords(ctx)
+	if err != nil {
+		return err
+	}
+
+	addresses := c.addresses()
+
+	stale := map[string][]string{}
+	for targetContext, record := range records {
+		removed := sets.New(record.CategoryAddresses(c.categories())...).Delete(addresses...)
+		if removed.Len() > 0 {
+			dbglog(ctx).Printf("Addresses %v removed from cluster %q", sets.List(removed), c.Cluster.Context)
+			stale[targetContext] = sets.List(removed)
+		}
+	}
+
+	// Record the new addresses before modifying the nodes, and remove the
+	// stale addresses only after they were unblocked.
+	if err := c.recordBlock(ctx, options.Method, options.Expire, false); err != nil {
+		return err
+	}
+
+	unit := expiryUnit(c.Cluster.Context)
+
+	_, err = c.modifyNodes(ctx, "Reconciling", "reconciled", func(target *targetCluster, nodeName string) error {
+		if len(stale[target.Context]) > 0 {
+			err := unblockNode(ctx, c.executor, target.Context, nodeName,
+				stale[target.Context], options.Filter, unit, false)
+			if err != nil {
+				return err
+			}
+		}
+		return blockNode(ctx, c.executor, target.Context, nodeName,
+			options.Method, addresses, options.Filter, unit, options.Expire)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.recordBlock(ctx, options.Method, options.Expire, true)
+}
+
+// routesListWatch returns a list watch for all blocked cluster routes.
+func (c *command) routesListWatch(ctx context.Context) *cache.ListWatch {
+	routes := c.Cluster.routeClient.Routes(metav1.NamespaceAll)
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return routes.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return routes.Watch(ctx, options)
+		},
+	}
+}
+
+// blockedNodesHandler notifies when blocked cluster nodes are added or
+// removed, or when a node external address has changed.
+func blockedNodesHandler(clusterContext string, notify func(string)) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList {
+				notify("Node " + objectName(obj) + " added in cluster " + clusterContext)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, newNode := oldObj.(*apiv1.Node), newObj.(*apiv1.Node)
+			oldIP, _ := externalIP(oldNode)
+			newIP, _ := externalIP(newNode)
+			if oldIP != newIP {
+				notify("Node " + newNode.Name + " address changed in cluster " + clusterContext)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			notify("Node " + objectName(obj) + " removed in cluster " + clusterContext)
+		},
+	}
+}
+
+// targetNodesHandler notifies when target nodes are added. Removed nodes do
+// not need any change.
+func targetNodesHandler(targetContext string, notify func(string)) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList {
+				notify("Node " + objectName(obj) + " added in target " + targetContext)
+			}
+		},
+	}
+}
+
+// routesHandler notifies when blocked cluster routes are added or removed, or
+// when a route ingress has changed.
+func routesHandler(clusterContext string, notify func(string)) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList {
+				notify("Route " + objectName(obj) + " added in cluster " + clusterContext)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldRoute, newRoute := oldObj.(*routeapi.Route), newObj.(*routeapi.Route)
+			if !reflect.DeepEqual(routeHosts(oldRoute), routeHosts(newRoute)) {
+				notify("Route " + objectName(newObj) + " changed in cluster " + clusterContext)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			notify("Route " + objectName(obj) + " removed in cluster " + clusterContext)
+		},
+	}
+}
+
+func routeHosts(route *routeapi.Route) []string {
+	var res []string
+	for _, ingress := range route.Status.Ingress {
+		res = append(res, ingress.Host)
+	}
+	return res
+}
+
+// objectName returns "namespace/name" or "name" for objects and deleted
+// objects tombstones.
+func objectName(obj interface{}) string {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return "(unknown)"
+	}
+	return key
+}
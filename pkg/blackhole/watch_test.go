@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: The oc-blackhole authors
+// SPDX-License-Identifier: Apache-2.0
+
+package blackhole
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestWatchCluster(t *testing.T) {
+	executor := newFakeExecutor()
+	c := newFakeCommand(executor)
+	c.watchDelay = 10 * time.Millisecond
+	c.watchRetry = 10 * time.Millisecond
+
+	options := &blockOptions{Method: MethodRoute}
+	if err := c.BlockCluster(context.TODO(), options); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new node in a target cluster before watching is reported in the
+	// initial list, and blocked when the watch starts.
+	_, err := c.Targets[0].k8sClient.CoreV1().Nodes().Create(
+		context.TODO(), fakeNode("hub-3", ""), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.WatchCluster(ctx, options)
+	}()
+
+	// The informers are synced before the first reconcile.
+	waitForRoutes(t, executor, "hub", "hub-3", sets.New(fakeClusterAddresses...))
+
+	// A new route in the blocked cluster, and a removed blocked node.
+	_, err = c.Cluster.routeClient.Routes("default").Create(
+		context.TODO(), fakeRouteObject(fakeRoute{Name: "new", Hosts: []string{"10.0.0.201"}}), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Cluster.k8sClient.CoreV1().Nodes().Delete(context.TODO(), "blocked-3", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := sets.New(fakeClusterAddresses...).Delete("10.0.0.3").Insert("10.0.0.201")
+	nodes := map[string][]string{
+		"hub":      {"hub-1", "hub-2", "hub-3"},
+		"cluster2": {"cluster2-1", "cluster2-2", "cluster2-3"},
+	}
+	for target, nodeNames := range nodes {
+		for _, node := range nodeNames {
+			waitForRoutes(t, executor, target, node, expected)
+		}
+	}
+
+	// Reconciling is done when the watch returns.
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := readRecord(context.TODO(), c.Targets[0], "blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addresses := sets.New(record.CategoryAddresses(AllCategories)...); !addresses.Equal(expected) {
+		t.Errorf("expected recorded addresses %v, got %v", sets.List(expected), sets.List(addresses))
+	}
+}
+
+func waitForRoutes(t *testing.T, executor *fakeExecutor, context string, node string, expected sets.Set[string]) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		routes := executor.Routes(context, node)
+		if routes.Equal(expected) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s/%s routes %v, got %v",
+				context, node, sets.List(expected), sets.List(routes))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}